@@ -0,0 +1,92 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const defaultBrand = "octopus_uk"
+
+// brandPresets are the endpoints of Kraken-powered brands that expose the
+// same GraphQL schema. Only Octopus UK has a community feed and the meter
+// point API used to look up power-ups regions
+var brandPresets = map[string]EndpointsConfig{
+	"octopus_uk": {
+		GraphQL:      "https://api.octopus.energy/v1/graphql/",
+		DavidKendall: "https://oe-api.davidskendall.co.uk/free_electricity.json",
+		MeterPoints:  "https://api.octopus.energy/v1/electricity-meter-points/",
+	},
+	"octopus_de": {
+		GraphQL: "https://api.oeg-kraken.energy/v1/graphql/",
+	},
+	"octopus_fr": {
+		GraphQL: "https://api.oefr-kraken.energy/v1/graphql/",
+	},
+	"octopus_jp": {
+		GraphQL: "https://api.oejp-kraken.energy/v1/graphql/",
+	},
+}
+
+// brandNames lists the available presets for error messages
+func brandNames() string {
+	names := make([]string, 0, len(brandPresets))
+	for name := range brandPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyBrand fills any endpoint not set explicitly from the brand preset, and
+// disables the community feed by default when the brand has none
+func applyBrand(config *Config) error {
+	if config.Brand == "" {
+		config.Brand = defaultBrand
+	}
+
+	preset, ok := brandPresets[config.Brand]
+	if !ok {
+		return fmt.Errorf("unknown brand %q (available: %s)", config.Brand, brandNames())
+	}
+
+	if config.Endpoints.GraphQL == "" {
+		config.Endpoints.GraphQL = preset.GraphQL
+	}
+	if config.Endpoints.DavidKendall == "" {
+		config.Endpoints.DavidKendall = preset.DavidKendall
+	}
+	if config.Endpoints.MeterPoints == "" {
+		config.Endpoints.MeterPoints = preset.MeterPoints
+	}
+
+	if config.Endpoints.DavidKendall == "" {
+		if _, configured := config.Sources["david_kendall"]; !configured {
+			disabled := false
+			if config.Sources == nil {
+				config.Sources = make(map[string]SourceConfig)
+			}
+			config.Sources["david_kendall"] = SourceConfig{Enabled: &disabled}
+		} else if sourceEnabled(config, "david_kendall") {
+			return fmt.Errorf("brand %q has no david_kendall feed, set endpoints.davidKendallURL", config.Brand)
+		}
+	}
+
+	return nil
+}
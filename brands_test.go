@@ -0,0 +1,72 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import "testing"
+
+func TestApplyBrand_DefaultsToOctopusUK(t *testing.T) {
+	config := &Config{}
+	if err := applyBrand(config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if config.Brand != "octopus_uk" || config.Endpoints != brandPresets["octopus_uk"] {
+		t.Errorf("Expected Octopus UK endpoints, got %s %+v", config.Brand, config.Endpoints)
+	}
+	if !sourceEnabled(config, "david_kendall") {
+		t.Error("Expected david_kendall to stay enabled for Octopus UK")
+	}
+}
+
+func TestApplyBrand_OverridesWin(t *testing.T) {
+	config := &Config{Brand: "octopus_uk", Endpoints: EndpointsConfig{GraphQL: "http://localhost:8080/graphql"}}
+	if err := applyBrand(config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if config.Endpoints.GraphQL != "http://localhost:8080/graphql" {
+		t.Errorf("Expected GraphQL override to be kept, got %s", config.Endpoints.GraphQL)
+	}
+	if config.Endpoints.DavidKendall != brandPresets["octopus_uk"].DavidKendall {
+		t.Errorf("Expected other endpoints from the preset, got %+v", config.Endpoints)
+	}
+}
+
+func TestApplyBrand_NoCommunityFeed(t *testing.T) {
+	config := &Config{Brand: "octopus_de"}
+	if err := applyBrand(config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if config.Endpoints.GraphQL != "https://api.oeg-kraken.energy/v1/graphql/" {
+		t.Errorf("Unexpected GraphQL endpoint %s", config.Endpoints.GraphQL)
+	}
+	if sourceEnabled(config, "david_kendall") {
+		t.Error("Expected david_kendall to be disabled for a brand without a feed")
+	}
+
+	enabled := true
+	config = &Config{Brand: "octopus_de", Sources: map[string]SourceConfig{"david_kendall": {Enabled: &enabled}}}
+	if err := applyBrand(config); err == nil {
+		t.Error("Expected error enabling david_kendall without a feed URL, got nil")
+	}
+}
+
+func TestApplyBrand_Unknown(t *testing.T) {
+	if err := applyBrand(&Config{Brand: "octopus_mars"}); err == nil {
+		t.Error("Expected error for unknown brand, got nil")
+	}
+}
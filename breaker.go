@@ -0,0 +1,167 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Circuit breaker defaults, used for any setting left unset in the configuration
+const (
+	defaultBreakerFailureThreshold = 3
+	defaultBreakerCoolOff          = 6 * time.Hour
+)
+
+// sourceHealth is the persisted circuit breaker state of a single source
+type sourceHealth struct {
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	LastSuccess         *time.Time `json:"lastSuccess,omitempty"`
+	LastFailure         *time.Time `json:"lastFailure,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	OpenUntil           *time.Time `json:"openUntil,omitempty"`
+}
+
+// circuitBreaker skips sources that keep failing until a cool-off has passed,
+// after which the source is tried again and the breaker reopens if it still fails
+type circuitBreaker struct {
+	path             string
+	failureThreshold int
+	coolOff          time.Duration
+	Sources          map[string]*sourceHealth `json:"sources"`
+}
+
+// healthFilePath returns the configured breaker state file, defaulting to the cache directory
+func healthFilePath(config *Config) string {
+	if config.CircuitBreaker.HealthFile != "" {
+		return config.CircuitBreaker.HealthFile
+	}
+	return filepath.Join(cacheDir, "health.json")
+}
+
+// loadCircuitBreaker reads the breaker state from the health file
+func loadCircuitBreaker(config *Config) (*circuitBreaker, error) {
+	breaker := &circuitBreaker{
+		path:             healthFilePath(config),
+		failureThreshold: config.CircuitBreaker.FailureThreshold,
+		coolOff:          config.CircuitBreaker.CoolOff,
+		Sources:          make(map[string]*sourceHealth),
+	}
+	if breaker.failureThreshold <= 0 {
+		breaker.failureThreshold = defaultBreakerFailureThreshold
+	}
+	if breaker.coolOff <= 0 {
+		breaker.coolOff = defaultBreakerCoolOff
+	}
+
+	data, err := os.ReadFile(breaker.path)
+	if os.IsNotExist(err) {
+		return breaker, nil
+	}
+	if err != nil {
+		return breaker, err
+	}
+	if err := json.Unmarshal(data, breaker); err != nil {
+		return breaker, err
+	}
+	if breaker.Sources == nil {
+		breaker.Sources = make(map[string]*sourceHealth)
+	}
+
+	return breaker, nil
+}
+
+// save writes the breaker state to the health file
+func (b *circuitBreaker) save() error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(b.path, data, 0644)
+}
+
+// filter returns the sources whose breaker is closed, logging those skipped
+func (b *circuitBreaker) filter(sources []scheduledSource, now time.Time) []scheduledSource {
+	allowed := make([]scheduledSource, 0, len(sources))
+	for _, scheduled := range sources {
+		name := scheduled.source.Name()
+		health := b.Sources[name]
+		if health != nil && health.OpenUntil != nil && now.Before(*health.OpenUntil) {
+			slog.Warn("Skipping source, circuit breaker is open",
+				"source", name,
+				"consecutive_failures", health.ConsecutiveFailures,
+				"last_error", health.LastError,
+				"last_success", health.LastSuccess,
+				"open_until", *health.OpenUntil)
+			continue
+		}
+		allowed = append(allowed, scheduled)
+	}
+	return allowed
+}
+
+// record updates each fetched source's health, opening the breaker of any
+// source that has reached the failure threshold. Failures are not counted
+// once the run itself was cancelled or ran out of time, since they say
+// nothing about the sources
+func (b *circuitBreaker) record(ctx context.Context, results []sourceResult, now time.Time) {
+	for _, result := range results {
+		if result.err != nil && ctx.Err() != nil {
+			slog.Info("Run interrupted, not counting source failure", "source", result.name, "error", result.err)
+			continue
+		}
+
+		health, ok := b.Sources[result.name]
+		if !ok {
+			health = &sourceHealth{}
+			b.Sources[result.name] = health
+		}
+
+		if result.err == nil {
+			if health.ConsecutiveFailures >= b.failureThreshold {
+				slog.Info("Source recovered, closing circuit breaker", "source", result.name)
+			}
+			lastSuccess := now
+			health.ConsecutiveFailures = 0
+			health.LastSuccess = &lastSuccess
+			health.LastError = ""
+			health.OpenUntil = nil
+			continue
+		}
+
+		lastFailure := now
+		health.ConsecutiveFailures++
+		health.LastFailure = &lastFailure
+		health.LastError = result.err.Error()
+
+		if health.ConsecutiveFailures >= b.failureThreshold {
+			openUntil := now.Add(b.coolOff)
+			health.OpenUntil = &openUntil
+			slog.Warn("Opening circuit breaker for failing source",
+				"source", result.name,
+				"consecutive_failures", health.ConsecutiveFailures,
+				"open_until", *health.OpenUntil)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_OpensAfterThreshold(t *testing.T) {
+	healthFile := filepath.Join(t.TempDir(), "health.json")
+	config := &Config{CircuitBreaker: CircuitBreakerConfig{FailureThreshold: 2, CoolOff: time.Hour, HealthFile: healthFile}}
+	sources := []scheduledSource{
+		{source: &fakeSource{name: "flaky"}, timeout: time.Second},
+		{source: &fakeSource{name: "healthy"}, timeout: time.Second},
+	}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	failed := []sourceResult{{name: "flaky", err: errors.New("boom")}, {name: "healthy"}}
+
+	breaker, err := loadCircuitBreaker(config)
+	if err != nil {
+		t.Fatalf("Failed to load breaker: %v", err)
+	}
+	breaker.record(context.Background(), failed, now)
+	if got := breaker.filter(sources, now); len(got) != 2 {
+		t.Fatalf("Expected breaker to stay closed below the threshold, got %d sources", len(got))
+	}
+	breaker.record(context.Background(), failed, now)
+	if err := breaker.save(); err != nil {
+		t.Fatalf("Failed to save breaker: %v", err)
+	}
+
+	// A later run loads the persisted state and skips the failing source
+	breaker, err = loadCircuitBreaker(config)
+	if err != nil {
+		t.Fatalf("Failed to load breaker: %v", err)
+	}
+	got := breaker.filter(sources, now.Add(30*time.Minute))
+	if len(got) != 1 || got[0].source.Name() != "healthy" {
+		t.Fatalf("Expected only the healthy source while open, got %d sources", len(got))
+	}
+	if health := breaker.Sources["flaky"]; health.ConsecutiveFailures != 2 || health.LastError != "boom" {
+		t.Errorf("Unexpected health %+v", health)
+	}
+
+	// After the cool-off the source is tried again and recovers
+	if got := breaker.filter(sources, now.Add(2*time.Hour)); len(got) != 2 {
+		t.Fatalf("Expected source to be retried after the cool-off, got %d sources", len(got))
+	}
+	breaker.record(context.Background(), []sourceResult{{name: "flaky"}}, now.Add(2*time.Hour))
+	if health := breaker.Sources["flaky"]; health.ConsecutiveFailures != 0 || health.OpenUntil != nil {
+		t.Errorf("Expected success to close the breaker, got %+v", health)
+	}
+}
+
+func TestCircuitBreaker_ReopensOnFailedTrial(t *testing.T) {
+	breaker, _ := loadCircuitBreaker(&Config{CircuitBreaker: CircuitBreakerConfig{HealthFile: filepath.Join(t.TempDir(), "health.json")}})
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	failed := []sourceResult{{name: "down", err: errors.New("boom")}}
+
+	for i := 0; i < defaultBreakerFailureThreshold; i++ {
+		breaker.record(context.Background(), failed, now)
+	}
+
+	later := now.Add(defaultBreakerCoolOff + time.Minute)
+	breaker.record(context.Background(), failed, later)
+	if openUntil := breaker.Sources["down"].OpenUntil; openUntil == nil || !openUntil.Equal(later.Add(defaultBreakerCoolOff)) {
+		t.Errorf("Expected a failed trial to reopen the breaker, got %+v", breaker.Sources["down"])
+	}
+}
+
+func TestCircuitBreaker_IgnoresInterruptedRun(t *testing.T) {
+	config := &Config{CircuitBreaker: CircuitBreakerConfig{
+		FailureThreshold: 1, CoolOff: time.Hour, HealthFile: filepath.Join(t.TempDir(), "health.json"),
+	}}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	breaker, err := loadCircuitBreaker(config)
+	if err != nil {
+		t.Fatalf("Failed to load breaker: %v", err)
+	}
+
+	// The run was stopped, so every source failed with it
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	breaker.record(ctx, []sourceResult{
+		{name: "octopus", err: context.Canceled},
+		{name: "david_kendall"},
+	}, now)
+
+	if health, ok := breaker.Sources["octopus"]; ok && (health.ConsecutiveFailures != 0 || health.OpenUntil != nil) {
+		t.Errorf("Expected an interrupted run not to count against the source, got %+v", health)
+	}
+	if health := breaker.Sources["david_kendall"]; health == nil || health.LastSuccess == nil {
+		t.Errorf("Expected successes to still be recorded, got %+v", health)
+	}
+}
@@ -17,8 +17,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,53 +35,99 @@ import (
 	"github.com/pkg/errors"
 )
 
+// tokenRenewalMargin is how long before expiry a token is renewed
+const tokenRenewalMargin = 5 * time.Minute
+
+// defaultTokenLifetime is assumed when a token's expiry cannot be decoded
+const defaultTokenLifetime = time.Hour
+
 type AuthenticatedClient struct {
-	apiKey       string
-	graphqlURL   string
-	client       *graphql.Client
-	token        string
-	tokenExpiry  time.Time
-	refreshToken string
-	mutex        sync.RWMutex
+	apiKey        string
+	graphqlURL    string
+	client        *graphql.Client
+	httpClient    *http.Client
+	token         string
+	tokenExpiry   time.Time
+	refreshToken  string
+	refreshExpiry time.Time
+	store         *tokenStore
+	storeLoaded   bool
+	retry         retryPolicy
+	mutex         sync.RWMutex
+}
+
+// ClientOption configures an AuthenticatedClient
+type ClientOption func(*AuthenticatedClient)
+
+// WithHTTPClient makes requests with the given HTTP client
+func WithHTTPClient(httpClient *http.Client) ClientOption {
+	return func(c *AuthenticatedClient) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetryPolicy retries requests that fail with a transient error
+func WithRetryPolicy(policy retryPolicy) ClientOption {
+	return func(c *AuthenticatedClient) {
+		c.retry = policy
+	}
+}
+
+// WithTokenStore persists tokens in the given store so later runs can reuse them
+func WithTokenStore(store *tokenStore) ClientOption {
+	return func(c *AuthenticatedClient) {
+		c.store = store
+	}
 }
 
+// ObtainTokenInput authenticates with either an API key or a refresh token
 type ObtainTokenInput struct {
-	APIKey string `json:"APIKey"`
+	APIKey       string `json:"APIKey,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
 }
 
 type TokenResponse struct {
-	Token            string `json:"token"`
-	RefreshToken     string `json:"refreshToken"`
-	RefreshExpiresIn int    `json:"refreshExpiresIn"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	// RefreshExpiresIn is when the refresh token expires, as a Unix timestamp
+	RefreshExpiresIn int64 `json:"refreshExpiresIn"`
 }
 
 type ObtainTokenMutation struct {
 	ObtainKrakenToken TokenResponse `json:"obtainKrakenToken"`
 }
 
-func NewAuthenticatedClient(apiKey, graphqlURL string) *AuthenticatedClient {
-	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:        10,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-			DisableCompression:  false,
-		},
+func NewAuthenticatedClient(apiKey, graphqlURL string, opts ...ClientOption) *AuthenticatedClient {
+	c := &AuthenticatedClient{
+		apiKey:     apiKey,
+		graphqlURL: graphqlURL,
+		retry:      retryPolicy{maxAttempts: 1},
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
 
-	client := graphql.NewClient(graphqlURL, graphql.WithHTTPClient(httpClient))
+	if c.httpClient == nil {
+		// The default settings are always valid
+		c.httpClient, _ = newHTTPClient(HTTPConfig{})
+	}
 
-	return &AuthenticatedClient{
-		apiKey:     apiKey,
-		graphqlURL: graphqlURL,
-		client:     client,
+	// Report GraphQL errors through the shared client's transport
+	base := c.httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
 	}
+	httpClient := *c.httpClient
+	httpClient.Transport = &graphqlErrorTransport{base: base}
+
+	c.client = graphql.NewClient(graphqlURL, graphql.WithHTTPClient(&httpClient))
+
+	return c
 }
 
 func (c *AuthenticatedClient) ensureValidToken(ctx context.Context) error {
 	c.mutex.RLock()
-	hasValidToken := c.token != "" && time.Now().Add(5*time.Minute).Before(c.tokenExpiry)
+	hasValidToken := c.token != "" && time.Now().Add(tokenRenewalMargin).Before(c.tokenExpiry)
 	c.mutex.RUnlock()
 
 	if hasValidToken {
@@ -82,14 +137,77 @@ func (c *AuthenticatedClient) ensureValidToken(ctx context.Context) error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	if c.token != "" && time.Now().Add(5*time.Minute).Before(c.tokenExpiry) {
+	if !c.storeLoaded {
+		c.storeLoaded = true
+		c.loadStoredToken()
+	}
+
+	if c.token != "" && time.Now().Add(tokenRenewalMargin).Before(c.tokenExpiry) {
 		return nil
 	}
 
-	return c.obtainToken(ctx)
+	if err := c.renewToken(ctx); err != nil {
+		return err
+	}
+
+	c.saveStoredToken()
+	return nil
 }
 
-func (c *AuthenticatedClient) obtainToken(ctx context.Context) error {
+// loadStoredToken restores tokens saved by a previous run. The caller must hold the lock
+func (c *AuthenticatedClient) loadStoredToken() {
+	if c.store == nil || c.token != "" {
+		return
+	}
+
+	stored, err := c.store.load(c.apiKey)
+	if err != nil {
+		slog.Warn("Failed to load stored token", "error", err)
+		return
+	}
+	if stored == nil {
+		return
+	}
+
+	c.token = stored.Token
+	c.tokenExpiry = stored.TokenExpiry
+	c.refreshToken = stored.RefreshToken
+	c.refreshExpiry = stored.RefreshExpiry
+	slog.Debug("Loaded stored token", "expires", c.tokenExpiry)
+}
+
+// saveStoredToken persists the current tokens. The caller must hold the lock
+func (c *AuthenticatedClient) saveStoredToken() {
+	if c.store == nil {
+		return
+	}
+
+	err := c.store.save(c.apiKey, &storedToken{
+		Token:         c.token,
+		TokenExpiry:   c.tokenExpiry,
+		RefreshToken:  c.refreshToken,
+		RefreshExpiry: c.refreshExpiry,
+	})
+	if err != nil {
+		slog.Warn("Failed to store token", "error", err)
+	}
+}
+
+// renewToken uses the refresh token while it is valid, falling back to the
+// API key if there is none or the refresh fails. The caller must hold the lock
+func (c *AuthenticatedClient) renewToken(ctx context.Context) error {
+	if c.refreshToken != "" && time.Now().Before(c.refreshExpiry) {
+		err := c.obtainToken(ctx, ObtainTokenInput{RefreshToken: c.refreshToken})
+		if err == nil {
+			return nil
+		}
+		slog.Debug("Failed to refresh JWT token, using API key", "error", err)
+	}
+
+	return c.obtainToken(ctx, ObtainTokenInput{APIKey: c.apiKey})
+}
+
+func (c *AuthenticatedClient) obtainToken(ctx context.Context, input ObtainTokenInput) error {
 	mutation := `
 		mutation obtainKrakenToken($input: ObtainJSONWebTokenInput!) {
 			obtainKrakenToken(input: $input) {
@@ -101,9 +219,7 @@ func (c *AuthenticatedClient) obtainToken(ctx context.Context) error {
 	`
 
 	req := graphql.NewRequest(mutation)
-	req.Var("input", ObtainTokenInput{
-		APIKey: c.apiKey,
-	})
+	req.Var("input", input)
 
 	req.Header.Set("Content-Type", "application/json")
 
@@ -112,24 +228,368 @@ func (c *AuthenticatedClient) obtainToken(ctx context.Context) error {
 		return errors.Wrap(err, "failed to obtain JWT token")
 	}
 
+	now := time.Now()
 	c.token = response.ObtainKrakenToken.Token
-	c.refreshToken = response.ObtainKrakenToken.RefreshToken
-	c.tokenExpiry = time.Now().Add(time.Duration(response.ObtainKrakenToken.RefreshExpiresIn) * time.Second)
+	c.tokenExpiry = tokenExpiry(c.token, now)
+	if response.ObtainKrakenToken.RefreshToken != "" {
+		c.refreshToken = response.ObtainKrakenToken.RefreshToken
+		c.refreshExpiry = time.Unix(response.ObtainKrakenToken.RefreshExpiresIn, 0)
+	}
 
 	return nil
 }
 
+// tokenExpiry reads the exp claim from a JWT, assuming the default lifetime
+// if the token cannot be decoded
+func tokenExpiry(token string, now time.Time) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) == 3 {
+		payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+		if err == nil {
+			var claims struct {
+				Exp int64 `json:"exp"`
+			}
+			if json.Unmarshal(payload, &claims) == nil && claims.Exp > 0 {
+				return time.Unix(claims.Exp, 0)
+			}
+		}
+	}
+
+	return now.Add(defaultTokenLifetime)
+}
+
+// invalidateToken discards a token the API rejected so the next request renews it
+func (c *AuthenticatedClient) invalidateToken(token string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.token == token {
+		c.token = ""
+		c.tokenExpiry = time.Time{}
+	}
+}
+
+// isAuthError reports whether a request failed because its token was
+// missing, invalid or expired
+func isAuthError(err error) bool {
+	return errorClassOf(err) == ErrorClassAuth
+}
+
 func (c *AuthenticatedClient) Run(ctx context.Context, req *graphql.Request, resp interface{}) error {
+	return c.retry.do(ctx, "graphql", func() error {
+		return c.runAuthenticated(ctx, req, resp)
+	})
+}
+
+// runAuthenticated runs a request, renewing the token and retrying once if
+// the API rejects it
+func (c *AuthenticatedClient) runAuthenticated(ctx context.Context, req *graphql.Request, resp interface{}) error {
+	token, err := c.currentToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.runWithToken(ctx, token, req, resp)
+	if err == nil || !isAuthError(err) || ctx.Err() != nil {
+		return err
+	}
+
+	// The token may have expired or been revoked early, so renew it and retry once
+	slog.Debug("Request failed authentication, renewing token", "error", err)
+	c.invalidateToken(token)
+
+	token, err = c.currentToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.runWithToken(ctx, token, req, resp)
+}
+
+// currentToken returns a valid access token, renewing it if needed
+func (c *AuthenticatedClient) currentToken(ctx context.Context) (string, error) {
 	if err := c.ensureValidToken(ctx); err != nil {
-		return errors.Wrap(err, "failed to ensure valid token")
+		return "", errors.Wrap(err, "failed to ensure valid token")
 	}
 
 	c.mutex.RLock()
-	token := c.token
-	c.mutex.RUnlock()
+	defer c.mutex.RUnlock()
+	return c.token, nil
+}
 
+func (c *AuthenticatedClient) runWithToken(ctx context.Context, token string, req *graphql.Request, resp interface{}) error {
 	req.Header.Set("Authorization", token)
 	req.Header.Set("Content-Type", "application/json")
 
-	return c.client.Run(ctx, req, resp)
+	err := c.client.Run(ctx, req, resp)
+
+	// The transport reports GraphQL errors, which the HTTP client wraps in a
+	// url.Error; return them unwrapped so callers see the API's message
+	var graphqlErr *GraphQLError
+	if errors.As(err, &graphqlErr) {
+		return graphqlErr
+	}
+	return err
+}
+
+// ErrorClass groups API errors by how a caller should react to them
+type ErrorClass string
+
+const (
+	ErrorClassAuth       ErrorClass = "auth"
+	ErrorClassPermission ErrorClass = "permission"
+	ErrorClassNotFound   ErrorClass = "not_found"
+	ErrorClassValidation ErrorClass = "validation"
+	ErrorClassTransient  ErrorClass = "transient"
+	ErrorClassUnknown    ErrorClass = "unknown"
+)
+
+// krakenErrorClasses classifies the Kraken error codes we have seen; other
+// codes are classified by their extensions, HTTP status or message
+var krakenErrorClasses = map[string]ErrorClass{
+	"KT-CT-1111": ErrorClassAuth,      // Unauthorized
+	"KT-CT-1112": ErrorClassAuth,      // Authorization header not provided
+	"KT-CT-1124": ErrorClassAuth,      // JWT has expired
+	"KT-CT-1199": ErrorClassTransient, // Too many requests
+	"KT-CT-4123": ErrorClassPermission,
+}
+
+// GraphQLErrorDetail is a single entry of a GraphQL errors response
+type GraphQLErrorDetail struct {
+	Message    string        `json:"message"`
+	Path       []interface{} `json:"path,omitempty"`
+	Extensions struct {
+		ErrorCode        string `json:"errorCode"`
+		ErrorClass       string `json:"errorClass"`
+		ErrorType        string `json:"errorType"`
+		ErrorDescription string `json:"errorDescription"`
+	} `json:"extensions"`
+}
+
+// GraphQLError is a failed GraphQL response, either an errors payload or an
+// HTTP error status
+type GraphQLError struct {
+	StatusCode int
+	Errors     []GraphQLErrorDetail
+	Class      ErrorClass
+	RetryAfter time.Duration
+}
+
+func (e *GraphQLError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, detail := range e.Errors {
+		message := detail.Message
+		if detail.Extensions.ErrorCode != "" {
+			message += " (" + detail.Extensions.ErrorCode + ")"
+		}
+		if len(detail.Path) > 0 {
+			message += fmt.Sprintf(" at %v", detail.Path)
+		}
+		messages = append(messages, message)
+	}
+	if len(messages) == 0 {
+		messages = append(messages, fmt.Sprintf("unexpected status code: %d", e.StatusCode))
+	}
+	return "graphql: " + strings.Join(messages, "; ")
+}
+
+// Code returns the Kraken error code of the first error, if any
+func (e *GraphQLError) Code() string {
+	for _, detail := range e.Errors {
+		if detail.Extensions.ErrorCode != "" {
+			return detail.Extensions.ErrorCode
+		}
+	}
+	return ""
+}
+
+// errorClassOf classifies an error returned while talking to the API
+func errorClassOf(err error) ErrorClass {
+	var graphqlErr *GraphQLError
+	if errors.As(err, &graphqlErr) {
+		return graphqlErr.Class
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return classifyGraphQLError(statusErr.StatusCode, nil)
+	}
+
+	// A deadline that expired outside a request, such as a source's own
+	// timeout, is final; only a timed out request is worth retrying
+	var urlErr *url.Error
+	if errors.Is(err, context.DeadlineExceeded) && !errors.As(err, &urlErr) {
+		return ErrorClassUnknown
+	}
+
+	// url.Error satisfies net.Error itself, so look for the underlying
+	// network failure rather than treating every request error as transient
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorClassTransient
+	}
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	if errors.As(err, &opErr) || errors.As(err, &dnsErr) {
+		return ErrorClassTransient
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorClassTransient
+	}
+
+	return ErrorClassUnknown
+}
+
+// classifyGraphQLError picks the class of a failed response, preferring the
+// API's own classification over status codes and messages
+func classifyGraphQLError(statusCode int, details []GraphQLErrorDetail) ErrorClass {
+	for _, detail := range details {
+		if class, ok := krakenErrorClasses[detail.Extensions.ErrorCode]; ok {
+			return class
+		}
+
+		switch hint := strings.ToUpper(detail.Extensions.ErrorClass + " " + detail.Extensions.ErrorType); {
+		case strings.Contains(hint, "AUTHENTICATION"):
+			return ErrorClassAuth
+		case strings.Contains(hint, "AUTHORIZATION"), strings.Contains(hint, "PERMISSION"):
+			return ErrorClassPermission
+		case strings.Contains(hint, "NOT_FOUND"):
+			return ErrorClassNotFound
+		case strings.Contains(hint, "VALIDATION"):
+			return ErrorClassValidation
+		case strings.Contains(hint, "THROTTL"), strings.Contains(hint, "UNAVAILABLE"):
+			return ErrorClassTransient
+		}
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		return ErrorClassAuth
+	case statusCode == http.StatusForbidden:
+		return ErrorClassPermission
+	case statusCode == http.StatusNotFound:
+		return ErrorClassNotFound
+	case statusCode == http.StatusTooManyRequests, statusCode >= 500:
+		return ErrorClassTransient
+	}
+
+	for _, detail := range details {
+		message := strings.ToLower(detail.Message)
+		switch {
+		case strings.Contains(message, "jwt"), strings.Contains(message, "token"), strings.Contains(message, "authenticat"):
+			return ErrorClassAuth
+		case strings.Contains(message, "permission"), strings.Contains(message, "not authorized"), strings.Contains(message, "unauthorized"):
+			return ErrorClassPermission
+		case strings.Contains(message, "not found"), strings.Contains(message, "does not exist"):
+			return ErrorClassNotFound
+		case strings.Contains(message, "invalid"):
+			return ErrorClassValidation
+		}
+	}
+
+	if statusCode >= 400 {
+		return ErrorClassValidation
+	}
+	return ErrorClassUnknown
+}
+
+// graphqlErrorTransport turns GraphQL error payloads and HTTP error statuses
+// into GraphQLErrors, which the GraphQL client would otherwise reduce to a
+// bare message or a decoding failure
+type graphqlErrorTransport struct {
+	base http.RoundTripper
+}
+
+// partialErrorsKey is the context key of the partial errors collector
+type partialErrorsKey struct{}
+
+// withPartialErrors lets requests made with the context keep the data of a
+// partly failed response. Errors confined to named fields are collected into
+// errs instead of failing the request, unless retrying or renewing the
+// token could still fix them
+func withPartialErrors(ctx context.Context, errs *[]GraphQLErrorDetail) context.Context {
+	return context.WithValue(ctx, partialErrorsKey{}, errs)
+}
+
+// isPartialResponse reports whether a response with errors still carries
+// usable data for the fields that did not fail
+func isPartialResponse(statusCode int, data json.RawMessage, details []GraphQLErrorDetail) bool {
+	if statusCode >= 400 || len(data) == 0 || string(data) == "null" {
+		return false
+	}
+	for _, detail := range details {
+		if len(detail.Path) == 0 {
+			return false
+		}
+		switch classifyGraphQLError(statusCode, []GraphQLErrorDetail{detail}) {
+		case ErrorClassAuth, ErrorClassTransient:
+			return false
+		}
+	}
+	return true
+}
+
+// fieldErrors groups partial errors by the top level field, or alias, they
+// failed at
+func fieldErrors(details []GraphQLErrorDetail) map[string][]GraphQLErrorDetail {
+	fields := make(map[string][]GraphQLErrorDetail)
+	for _, detail := range details {
+		field := ""
+		if len(detail.Path) > 0 {
+			field = fmt.Sprint(detail.Path[0])
+		}
+		fields[field] = append(fields[field], detail)
+	}
+	return fields
+}
+
+// newFieldError builds the error of a single failed field of a partial response
+func newFieldError(details []GraphQLErrorDetail) *GraphQLError {
+	return &GraphQLError{
+		StatusCode: http.StatusOK,
+		Errors:     details,
+		Class:      classifyGraphQLError(http.StatusOK, details),
+	}
+}
+
+func (t *graphqlErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var payload struct {
+		Data   json.RawMessage      `json:"data"`
+		Errors []GraphQLErrorDetail `json:"errors"`
+	}
+	json.Unmarshal(body, &payload)
+
+	if len(payload.Errors) > 0 && isPartialResponse(resp.StatusCode, payload.Data, payload.Errors) {
+		if errs, ok := req.Context().Value(partialErrorsKey{}).(*[]GraphQLErrorDetail); ok {
+			*errs = append(*errs, payload.Errors...)
+			payload.Errors = nil
+			if body, err = json.Marshal(map[string]json.RawMessage{"data": payload.Data}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if resp.StatusCode >= 400 || len(payload.Errors) > 0 {
+		return nil, &GraphQLError{
+			StatusCode: resp.StatusCode,
+			Errors:     payload.Errors,
+			Class:      classifyGraphQLError(resp.StatusCode, payload.Errors),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	return resp, nil
 }
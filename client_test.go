@@ -17,7 +17,22 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/machinebox/graphql"
 )
 
 func TestNewAuthenticatedClient(t *testing.T) {
@@ -43,6 +58,213 @@ func TestNewAuthenticatedClient(t *testing.T) {
 	}
 }
 
-// Note: ensureValidToken, obtainToken, and Run methods make network calls
-// and are difficult to test without mocking. They remain at 0% coverage
-// but are tested indirectly through integration tests.
+// testJWT builds an unsigned JWT with the given expiry
+func testJWT(exp time.Time) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d}`, exp.Unix())))
+	return header + "." + payload + ".signature"
+}
+
+func TestTokenExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	exp := now.Add(15 * time.Minute)
+
+	if got := tokenExpiry(testJWT(exp), now); !got.Equal(exp) {
+		t.Errorf("Expected expiry %v from exp claim, got %v", exp, got)
+	}
+	if got := tokenExpiry("not-a-jwt", now); !got.Equal(now.Add(defaultTokenLifetime)) {
+		t.Errorf("Expected default lifetime for undecodable token, got %v", got)
+	}
+}
+
+// tokenTestServer is a fake Kraken endpoint that records how tokens are obtained
+type tokenTestServer struct {
+	mutex       sync.Mutex
+	apiKeyCalls int
+	refreshes   int
+	rejected    map[string]bool
+	tokenExpiry time.Duration
+	issued      int
+}
+
+func (s *tokenTestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var req graphqlTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if strings.Contains(req.Query, "obtainKrakenToken") {
+		input, _ := req.Variables["input"].(map[string]interface{})
+		if _, ok := input["refreshToken"]; ok {
+			s.refreshes++
+		} else {
+			s.apiKeyCalls++
+		}
+		s.issued++
+		token := fmt.Sprintf("%s-%d", testJWT(time.Now().Add(s.tokenExpiry)), s.issued)
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"obtainKrakenToken": map[string]interface{}{
+				"token":            token,
+				"refreshToken":     "test-refresh",
+				"refreshExpiresIn": time.Now().Add(7 * 24 * time.Hour).Unix(),
+			},
+		}})
+		return
+	}
+
+	if s.rejected[r.Header.Get("Authorization")] {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"errors": []interface{}{map[string]interface{}{"message": "Signature of the JWT has expired."}},
+		})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"ok": true}})
+}
+
+func TestAuthenticatedClient_RenewsWithRefreshToken(t *testing.T) {
+	// Tokens expire inside the renewal margin, so every request renews
+	fake := &tokenTestServer{tokenExpiry: time.Minute}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := NewAuthenticatedClient("test-api-key", server.URL)
+	for i := 0; i < 2; i++ {
+		var resp map[string]interface{}
+		if err := client.Run(context.Background(), graphql.NewRequest("query { ok }"), &resp); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if fake.apiKeyCalls != 1 || fake.refreshes != 1 {
+		t.Errorf("Expected one API key login and one refresh, got %d and %d", fake.apiKeyCalls, fake.refreshes)
+	}
+}
+
+func TestAuthenticatedClient_RefreshExpiryIsTimestamp(t *testing.T) {
+	fake := &tokenTestServer{tokenExpiry: time.Hour}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := NewAuthenticatedClient("test-api-key", server.URL)
+	var resp map[string]interface{}
+	if err := client.Run(context.Background(), graphql.NewRequest("query { ok }"), &resp); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The server returns a timestamp a week ahead, not a number of seconds
+	if until := time.Until(client.refreshExpiry); until < 6*24*time.Hour || until > 8*24*time.Hour {
+		t.Errorf("Expected the refresh token to expire in about a week, got %v", client.refreshExpiry)
+	}
+}
+
+func TestAuthenticatedClient_RetriesOnAuthError(t *testing.T) {
+	fake := &tokenTestServer{tokenExpiry: time.Hour, rejected: make(map[string]bool)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := NewAuthenticatedClient("test-api-key", server.URL)
+	var resp map[string]interface{}
+	if err := client.Run(context.Background(), graphql.NewRequest("query { ok }"), &resp); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The server revokes the current token before it expires
+	fake.mutex.Lock()
+	fake.rejected[client.token] = true
+	fake.mutex.Unlock()
+
+	if err := client.Run(context.Background(), graphql.NewRequest("query { ok }"), &resp); err != nil {
+		t.Fatalf("Expected request to succeed after renewing the token, got %v", err)
+	}
+	if fake.issued != 2 {
+		t.Errorf("Expected a second token to be issued, got %d tokens", fake.issued)
+	}
+}
+
+func TestGraphQLErrorTransport_ParsesErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data": null, "errors": [{
+			"message": "Signature of the JWT has expired.",
+			"path": ["account"],
+			"extensions": {"errorCode": "KT-CT-1124", "errorType": "AUTHORIZATION"}
+		}]}`))
+	}))
+	defer server.Close()
+
+	client := graphql.NewClient(server.URL, graphql.WithHTTPClient(&http.Client{
+		Transport: &graphqlErrorTransport{base: http.DefaultTransport},
+	}))
+
+	var resp map[string]interface{}
+	err := client.Run(context.Background(), graphql.NewRequest("query { account }"), &resp)
+
+	var graphqlErr *GraphQLError
+	if !errors.As(err, &graphqlErr) {
+		t.Fatalf("Expected GraphQLError, got %v", err)
+	}
+	if graphqlErr.Code() != "KT-CT-1124" || graphqlErr.Class != ErrorClassAuth {
+		t.Errorf("Expected KT-CT-1124 auth error, got %s %s", graphqlErr.Code(), graphqlErr.Class)
+	}
+	if !strings.Contains(graphqlErr.Error(), "KT-CT-1124") || !strings.Contains(graphqlErr.Error(), "account") {
+		t.Errorf("Expected error message to include code and path, got %q", graphqlErr.Error())
+	}
+}
+
+func TestClassifyGraphQLError(t *testing.T) {
+	detail := func(message, code, errorType string) []GraphQLErrorDetail {
+		d := GraphQLErrorDetail{Message: message}
+		d.Extensions.ErrorCode = code
+		d.Extensions.ErrorType = errorType
+		return []GraphQLErrorDetail{d}
+	}
+
+	tests := []struct {
+		name     string
+		status   int
+		details  []GraphQLErrorDetail
+		expected ErrorClass
+	}{
+		{"known code", 200, detail("Unauthorized.", "KT-CT-1111", ""), ErrorClassAuth},
+		{"error type", 200, detail("No account found.", "KT-CT-9999", "NOT_FOUND"), ErrorClassNotFound},
+		{"validation type", 200, detail("Bad input.", "", "VALIDATION"), ErrorClassValidation},
+		{"server error", 502, nil, ErrorClassTransient},
+		{"rate limited", 429, nil, ErrorClassTransient},
+		{"forbidden", 403, nil, ErrorClassPermission},
+		{"message", 200, detail("Account does not exist.", "", ""), ErrorClassNotFound},
+		{"unrecognised", 200, detail("Something odd.", "", ""), ErrorClassUnknown},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyGraphQLError(tt.status, tt.details); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestErrorClassOf(t *testing.T) {
+	if got := errorClassOf(&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}); got != ErrorClassTransient {
+		t.Errorf("Expected network error to be transient, got %s", got)
+	}
+	if got := errorClassOf(fmt.Errorf("wrapped: %w", context.DeadlineExceeded)); got == ErrorClassTransient {
+		t.Errorf("Expected an expired deadline not to be transient, got %s", got)
+	}
+	if got := errorClassOf(&url.Error{Op: "Post", URL: "", Err: fmt.Errorf("unsupported protocol scheme")}); got != ErrorClassUnknown {
+		t.Errorf("Expected request setup error not to be transient, got %s", got)
+	}
+	if got := errorClassOf(&url.Error{Op: "Post", URL: "https://example.com", Err: io.EOF}); got != ErrorClassTransient {
+		t.Errorf("Expected dropped connection to be transient, got %s", got)
+	}
+	if got := errorClassOf(fmt.Errorf("boom")); got != ErrorClassUnknown {
+		t.Errorf("Expected plain error to be unknown, got %s", got)
+	}
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// runFreezeCodes implements the freeze-codes command, a one-off migration
+// that pins the current numbering of every output file. Codes that are
+// already unique numbers are kept; missing, duplicate or non-numeric codes
+// are given the next free number so later runs can preserve them
+func runFreezeCodes(args []string) error {
+	flags := flag.NewFlagSet("freeze-codes", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to configuration file")
+	output := flags.String("output", "free_electricity.json", "Output file path")
+	dryRun := flags.Bool("dry-run", false, "Report what would change without writing")
+	flags.Parse(args)
+
+	config := &Config{OutputFile: *output}
+	if *configPath != "" {
+		if err := loadConfigFromFile(*configPath, config); err != nil {
+			return fmt.Errorf("failed to load config file: %w", err)
+		}
+	}
+
+	for _, target := range outputTargets(config) {
+		events, err := loadExistingEvents(target.OutputFile)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", target.OutputFile, err)
+		}
+
+		events, renumbered := freezeCodes(events)
+		slog.Info("Froze event codes", "campaign", target.Slug, "file", target.OutputFile,
+			"count", len(events), "renumbered", renumbered)
+
+		if renumbered == 0 || *dryRun {
+			continue
+		}
+		if err := saveEvents(events, target.OutputFile); err != nil {
+			return fmt.Errorf("failed to save %s: %w", target.OutputFile, err)
+		}
+	}
+
+	return nil
+}
+
+// freezeCodes keeps every unique positive numeric code and gives the other
+// events the next free codes, returning how many events were renumbered
+func freezeCodes(events []Event) ([]Event, int) {
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].StartAt.Before(events[j].StartAt)
+	})
+
+	used := make(map[string]bool, len(events))
+	kept := make([]Event, 0, len(events))
+	for _, event := range events {
+		if n, err := strconv.Atoi(event.Code); err != nil || n < 1 || used[event.Code] {
+			continue
+		}
+		used[event.Code] = true
+		kept = append(kept, event)
+	}
+
+	return assignStableCodes(kept, events), len(events) - len(kept)
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFreezeCodes(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	events := []Event{
+		{Code: "2", StartAt: day(2)},
+		{Code: "2", StartAt: day(3)},
+		{Code: "", StartAt: day(4)},
+		{Code: "5", StartAt: day(1)},
+		{Code: "MOCK-1", StartAt: day(5)},
+	}
+
+	result, renumbered := freezeCodes(events)
+
+	if renumbered != 3 {
+		t.Errorf("Expected 3 events to be renumbered, got %d", renumbered)
+	}
+	expected := []string{"5", "2", "6", "7", "8"}
+	actual := make([]string, len(result))
+	for i, event := range result {
+		actual[i] = event.Code
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected codes %v, got %v", expected, actual)
+	}
+}
+
+func TestFreezeCodes_SequentialUnchanged(t *testing.T) {
+	events := []Event{
+		{Code: "1", StartAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Code: "2", StartAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+	}
+
+	if _, renumbered := freezeCodes(events); renumbered != 0 {
+		t.Errorf("Expected existing sequential codes to be kept, got %d renumbered", renumbered)
+	}
+}
@@ -21,17 +21,137 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	AccountNumber string `yaml:"accountNumber"`
-	MeterPointID  string `yaml:"meterPointID"`
-	APIKey        string `yaml:"apiKey"`
-	OutputFile    string `yaml:"outputFile"`
+	AccountNumber        string                  `yaml:"accountNumber"`
+	MeterPointID         string                  `yaml:"meterPointID"`
+	APIKey               string                  `yaml:"apiKey"`
+	OutputFile           string                  `yaml:"outputFile"`
+	Sources              map[string]SourceConfig `yaml:"sources"`
+	MaxConcurrentFetches int                     `yaml:"maxConcurrentFetches"`
+	Campaigns            []CampaignConfig        `yaml:"campaigns"`
+	SavingSessionsOutput string                  `yaml:"savingSessionsOutputFile"`
+	PowerUps             PowerUpsConfig          `yaml:"powerUps"`
+	OptIn                OptInConfig             `yaml:"optIn"`
+	StateFile            string                  `yaml:"stateFile"`
+	PrivateOutputFile    string                  `yaml:"privateOutputFile"`
+	TokenCache           bool                    `yaml:"tokenCache"`
+	Retry                RetryConfig             `yaml:"retry"`
+	CircuitBreaker       CircuitBreakerConfig    `yaml:"circuitBreaker"`
+	RunTimeout           time.Duration           `yaml:"runTimeout"`
+	HTTP                 HTTPConfig              `yaml:"http"`
+	Brand                string                  `yaml:"brand"`
+	Endpoints            EndpointsConfig         `yaml:"endpoints"`
+	RecordDir            string                  `yaml:"recordDir"`
+	ReplayDir            string                  `yaml:"replayDir"`
+	Reconcile            ReconcileConfig         `yaml:"reconcile"`
+	Cancellation         CancellationConfig      `yaml:"cancellation"`
+	MergePolicy          map[string]string       `yaml:"mergePolicy"`
+
+	// Clock pins the time of a run, so a replayed run stamps its events
+	// exactly as the recorded one did. Zero uses the current time
+	Clock time.Time `yaml:"-"`
+}
+
+// CancellationConfig controls when a future event missing from its
+// authoritative source is marked cancelled
+type CancellationConfig struct {
+	MissedRuns int `yaml:"missedRuns"`
+}
+
+// ReconcileConfig controls how rescheduled events are recognised
+type ReconcileConfig struct {
+	// Tolerance widens each window when matching a moved event by overlap;
+	// zero uses the default and a negative value matches on codes only
+	Tolerance time.Duration `yaml:"tolerance"`
+}
+
+// EndpointsConfig overrides the API endpoints of the selected brand
+type EndpointsConfig struct {
+	GraphQL      string `yaml:"graphqlURL"`
+	DavidKendall string `yaml:"davidKendallURL"`
+	MeterPoints  string `yaml:"meterPointsURL"`
+}
+
+// HTTPConfig configures the HTTP client shared by every source
+type HTTPConfig struct {
+	Proxy               string        `yaml:"proxy"`
+	CAFile              string        `yaml:"caFile"`
+	TLSMinVersion       string        `yaml:"tlsMinVersion"`
+	Timeout             time.Duration `yaml:"timeout"`
+	MaxIdleConns        int           `yaml:"maxIdleConns"`
+	MaxIdleConnsPerHost int           `yaml:"maxIdleConnsPerHost"`
+	MaxConnsPerHost     int           `yaml:"maxConnsPerHost"`
+	UserAgentSuffix     string        `yaml:"userAgentSuffix"`
+}
+
+// RetryConfig controls how transient API and HTTP failures are retried
+type RetryConfig struct {
+	MaxAttempts int           `yaml:"maxAttempts"`
+	BaseDelay   time.Duration `yaml:"baseDelay"`
+	MaxDelay    time.Duration `yaml:"maxDelay"`
+	Jitter      *float64      `yaml:"jitter"`
+}
+
+// CircuitBreakerConfig controls when a persistently failing source is skipped
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `yaml:"failureThreshold"`
+	CoolOff          time.Duration `yaml:"coolOff"`
+	HealthFile       string        `yaml:"healthFile"`
+}
+
+// SourceConfig overrides the registered defaults for a single event source
+type SourceConfig struct {
+	Enabled  *bool         `yaml:"enabled"`
+	Priority *int          `yaml:"priority"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// CampaignConfig describes a Kraken flexibility campaign to track
+type CampaignConfig struct {
+	Slug       string `yaml:"slug"`
+	OutputFile string `yaml:"outputFile"`
+	Enabled    *bool  `yaml:"enabled"`
+}
+
+// PowerUpsConfig selects the power-ups feed and the region to keep
+type PowerUpsConfig struct {
+	Region string `yaml:"region"`
+	URL    string `yaml:"url"`
+	File   string `yaml:"file"`
+}
+
+// OptInConfig controls automatically joining upcoming campaign events
+type OptInConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	DryRun      bool `yaml:"dryRun"`
+	MaxAttempts int  `yaml:"maxAttempts"`
 }
 
+const (
+	defaultCampaignSlug = "free_electricity"
+
+	// savingSessionsCampaign tags Octoplus Saving Sessions, which are not a
+	// flexibility campaign but are written to their own output in the same way
+	savingSessionsCampaign      = "saving_sessions"
+	defaultSavingSessionsOutput = "saving_sessions.json"
+
+	// defaultRunTimeout bounds a whole run so a hung upstream cannot stall it
+	defaultRunTimeout = 10 * time.Minute
+
+	// defaultCancellationMissedRuns is how many consecutive runs a future
+	// event must be missing from its authoritative source to be cancelled
+	defaultCancellationMissedRuns = 3
+
+	// defaultRescheduleTolerance matches a session moved by up to an hour
+	defaultRescheduleTolerance = time.Hour
+)
+
 var (
 	configFile    = flag.String("config", "", "Path to configuration file")
 	accountNumber = flag.String("account", "", "Octopus Energy Account Number")
@@ -40,6 +160,14 @@ var (
 	outputFile    = flag.String("output", "free_electricity.json", "Output file path")
 	logFormat     = flag.String("log-format", "auto", "Log format: 'json', 'text', or 'auto' (detects environment)")
 	version       = flag.Bool("version", false, "Show version information")
+	privateOutput = flag.String("private-output", "", "Private per-account participation output file path (disabled if empty)")
+	runTimeout    = flag.Duration("timeout", 0, "Overall deadline for the run (default 10m)")
+	brand         = flag.String("brand", "", "Kraken brand preset: octopus_uk (default), octopus_de, octopus_fr or octopus_jp")
+	graphqlURL    = flag.String("graphql-url", "", "Override the Kraken GraphQL endpoint")
+	feedURL       = flag.String("david-kendall-url", "", "Override David Kendall's feed URL")
+	recordDir     = flag.String("record", "", "Record every HTTP exchange, with credentials redacted, to this directory")
+	replayDir     = flag.String("replay", "", "Replay HTTP exchanges recorded with -record instead of using the network")
+	backfill      = flag.Bool("backfill", false, "Fetch the complete Octopus event history and merge it into the output file")
 )
 
 func loadConfig() (*Config, error) {
@@ -58,6 +186,34 @@ func loadConfig() (*Config, error) {
 		}
 	}
 
+	if *privateOutput != "" {
+		config.PrivateOutputFile = *privateOutput
+	}
+
+	if *runTimeout > 0 {
+		config.RunTimeout = *runTimeout
+	}
+
+	if *brand != "" {
+		config.Brand = *brand
+	}
+	if *graphqlURL != "" {
+		config.Endpoints.GraphQL = *graphqlURL
+	}
+	if *feedURL != "" {
+		config.Endpoints.DavidKendall = *feedURL
+	}
+
+	if *recordDir != "" {
+		config.RecordDir = *recordDir
+	}
+	if *replayDir != "" {
+		config.ReplayDir = *replayDir
+	}
+	if config.RecordDir != "" && config.ReplayDir != "" {
+		return nil, fmt.Errorf("record and replay cannot be used together")
+	}
+
 	if *accountNumber != "" {
 		config.AccountNumber = *accountNumber
 	} else if config.AccountNumber == "" {
@@ -88,9 +244,118 @@ func loadConfig() (*Config, error) {
 		return nil, fmt.Errorf("meter point ID is required (use -meter flag, config file, or METER_POINT_ID env var)")
 	}
 
+	if err := validateCampaigns(config.Campaigns); err != nil {
+		return nil, err
+	}
+
+	if err := applyBrand(config); err != nil {
+		return nil, err
+	}
+
+	if _, err := newHTTPClient(config.HTTP); err != nil {
+		return nil, fmt.Errorf("invalid http configuration: %w", err)
+	}
+
+	if jitter := config.Retry.Jitter; jitter != nil && (*jitter < 0 || *jitter > 1) {
+		return nil, fmt.Errorf("retry jitter must be between 0 and 1, got %v", *jitter)
+	}
+
+	if config.PrivateOutputFile != "" {
+		for _, target := range outputTargets(config) {
+			if filepath.Clean(target.OutputFile) == filepath.Clean(config.PrivateOutputFile) {
+				return nil, fmt.Errorf("private output file must differ from the public output %q", target.OutputFile)
+			}
+		}
+	}
+
+	if _, err := newMergePolicy(config.MergePolicy); err != nil {
+		return nil, err
+	}
+
+	if config.PowerUps.Region != "" && normalizeRegion(config.PowerUps.Region) == "" {
+		return nil, fmt.Errorf("unknown power-ups region %q (use a GSP group such as _C or a region name such as London)", config.PowerUps.Region)
+	}
+
 	return config, nil
 }
 
+// validateCampaigns checks that every configured campaign is usable
+func validateCampaigns(campaigns []CampaignConfig) error {
+	slugs := make(map[string]bool, len(campaigns))
+	outputs := make(map[string]bool, len(campaigns))
+
+	for i, campaign := range campaigns {
+		if campaign.Slug == "" {
+			return fmt.Errorf("campaign %d is missing a slug", i+1)
+		}
+		if campaign.Slug == savingSessionsCampaign {
+			return fmt.Errorf("campaign slug %q is reserved, enable the saving_sessions source instead", campaign.Slug)
+		}
+		if slugs[campaign.Slug] {
+			return fmt.Errorf("campaign %q is configured more than once", campaign.Slug)
+		}
+		slugs[campaign.Slug] = true
+
+		if campaign.OutputFile != "" {
+			if outputs[campaign.OutputFile] {
+				return fmt.Errorf("output file %q is used by more than one campaign", campaign.OutputFile)
+			}
+			outputs[campaign.OutputFile] = true
+		}
+	}
+
+	return nil
+}
+
+// runClock returns the time of the run, pinned when recording or replaying
+func runClock(config *Config) time.Time {
+	if !config.Clock.IsZero() {
+		return config.Clock
+	}
+	return time.Now()
+}
+
+// outputTargets returns every campaign output to update this run, including
+// Saving Sessions when that source is enabled
+func outputTargets(config *Config) []CampaignConfig {
+	targets := enabledCampaigns(config)
+
+	if sourceEnabled(config, "saving_sessions") {
+		output := config.SavingSessionsOutput
+		if output == "" {
+			output = defaultSavingSessionsOutput
+		}
+		targets = append(targets, CampaignConfig{Slug: savingSessionsCampaign, OutputFile: output})
+	}
+
+	return targets
+}
+
+// enabledCampaigns returns the campaigns to track, defaulting to the free
+// electricity campaign written to OutputFile when none are configured
+func enabledCampaigns(config *Config) []CampaignConfig {
+	if len(config.Campaigns) == 0 {
+		return []CampaignConfig{{Slug: defaultCampaignSlug, OutputFile: config.OutputFile}}
+	}
+
+	campaigns := make([]CampaignConfig, 0, len(config.Campaigns))
+	for _, campaign := range config.Campaigns {
+		if campaign.Enabled != nil && !*campaign.Enabled {
+			continue
+		}
+		if campaign.OutputFile == "" {
+			if campaign.Slug == defaultCampaignSlug {
+				campaign.OutputFile = config.OutputFile
+			} else {
+				campaign.OutputFile = campaign.Slug + ".json"
+			}
+		}
+		campaigns = append(campaigns, campaign)
+	}
+
+	return campaigns
+}
+
 func loadConfigFromFile(filename string, config *Config) error {
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -307,3 +307,73 @@ func TestLoadConfig_MissingMeterPointID(t *testing.T) {
 		t.Errorf("Expected error '%s', got '%s'", expectedError, err.Error())
 	}
 }
+
+func TestEnabledCampaigns_Default(t *testing.T) {
+	config := &Config{OutputFile: "events.json"}
+
+	campaigns := enabledCampaigns(config)
+	if len(campaigns) != 1 {
+		t.Fatalf("Expected 1 default campaign, got %d", len(campaigns))
+	}
+	if campaigns[0].Slug != "free_electricity" || campaigns[0].OutputFile != "events.json" {
+		t.Errorf("Unexpected default campaign %+v", campaigns[0])
+	}
+}
+
+func TestEnabledCampaigns_Configured(t *testing.T) {
+	disabled := false
+	config := &Config{
+		OutputFile: "free_electricity.json",
+		Campaigns: []CampaignConfig{
+			{Slug: "free_electricity"},
+			{Slug: "other_campaign"},
+			{Slug: "custom", OutputFile: "custom_events.json"},
+			{Slug: "paused", Enabled: &disabled},
+		},
+	}
+
+	campaigns := enabledCampaigns(config)
+	if len(campaigns) != 3 {
+		t.Fatalf("Expected 3 enabled campaigns, got %d", len(campaigns))
+	}
+
+	expected := []string{"free_electricity.json", "other_campaign.json", "custom_events.json"}
+	for i, file := range expected {
+		if campaigns[i].OutputFile != file {
+			t.Errorf("Expected campaign %q to write %q, got %q", campaigns[i].Slug, file, campaigns[i].OutputFile)
+		}
+	}
+}
+
+func TestValidateCampaigns(t *testing.T) {
+	if err := validateCampaigns([]CampaignConfig{{Slug: "a"}, {Slug: "b"}}); err != nil {
+		t.Errorf("Unexpected error for valid campaigns: %v", err)
+	}
+	if err := validateCampaigns([]CampaignConfig{{OutputFile: "x.json"}}); err == nil {
+		t.Error("Expected error for campaign without slug")
+	}
+	if err := validateCampaigns([]CampaignConfig{{Slug: "a"}, {Slug: "a"}}); err == nil {
+		t.Error("Expected error for duplicate campaign slug")
+	}
+	if err := validateCampaigns([]CampaignConfig{{Slug: "a", OutputFile: "x.json"}, {Slug: "b", OutputFile: "x.json"}}); err == nil {
+		t.Error("Expected error for shared output file")
+	}
+}
+
+func TestOutputTargets_SavingSessions(t *testing.T) {
+	config := &Config{OutputFile: "free_electricity.json"}
+	if targets := outputTargets(config); len(targets) != 1 {
+		t.Fatalf("Expected saving sessions to be disabled by default, got %d targets", len(targets))
+	}
+
+	enabled := true
+	config.Sources = map[string]SourceConfig{"saving_sessions": {Enabled: &enabled}}
+
+	targets := outputTargets(config)
+	if len(targets) != 2 {
+		t.Fatalf("Expected 2 targets, got %d", len(targets))
+	}
+	if targets[1].Slug != savingSessionsCampaign || targets[1].OutputFile != "saving_sessions.json" {
+		t.Errorf("Unexpected saving sessions target %+v", targets[1])
+	}
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// exitNotEnrolled is the exit status used when the run completes but the
+// meter point is not enrolled in one or more campaigns
+const exitNotEnrolled = 3
+
+// errNotEnrolled is returned when the meter point is not enrolled in a campaign
+var errNotEnrolled = errors.New("meter point is not enrolled")
+
+// EnrollmentRecord is one period during which enrollment in a campaign stayed the same
+type EnrollmentRecord struct {
+	Campaign string    `json:"campaign"`
+	Enrolled bool      `json:"enrolled"`
+	Since    time.Time `json:"since"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// collectEnrollment gathers campaign enrollment reported by the fetched sources
+func collectEnrollment(results []sourceResult) map[string]bool {
+	enrollment := make(map[string]bool)
+	for _, result := range results {
+		for campaign, enrolled := range result.enrollment {
+			enrollment[campaign] = enrolled
+		}
+	}
+	return enrollment
+}
+
+// recordEnrollment appends enrollment observations to the history, opening a
+// new record only when a campaign's enrollment changes
+func recordEnrollment(history []EnrollmentRecord, enrollment map[string]bool, now time.Time) []EnrollmentRecord {
+	latest := make(map[string]int, len(enrollment))
+	for i, record := range history {
+		latest[record.Campaign] = i
+	}
+
+	campaigns := make([]string, 0, len(enrollment))
+	for campaign := range enrollment {
+		campaigns = append(campaigns, campaign)
+	}
+	sort.Strings(campaigns)
+
+	for _, campaign := range campaigns {
+		enrolled := enrollment[campaign]
+		if i, ok := latest[campaign]; ok && history[i].Enrolled == enrolled {
+			history[i].LastSeen = now
+			continue
+		}
+
+		if _, ok := latest[campaign]; ok {
+			slog.Warn("Campaign enrollment changed", "campaign", campaign, "enrolled", enrolled)
+		}
+		history = append(history, EnrollmentRecord{
+			Campaign: campaign,
+			Enrolled: enrolled,
+			Since:    now,
+			LastSeen: now,
+		})
+	}
+
+	return history
+}
+
+// checkEnrollment logs and persists enrollment, returning errNotEnrolled if
+// the meter point is missing from any campaign
+func checkEnrollment(config *Config, results []sourceResult) error {
+	enrollment := collectEnrollment(results)
+	if len(enrollment) == 0 {
+		return nil
+	}
+
+	statePath := stateFilePath(config)
+	state, err := loadState(statePath)
+	if err != nil {
+		slog.Warn("Failed to load state, enrollment history not recorded", "error", err)
+	} else {
+		state.Enrollment = recordEnrollment(state.Enrollment, enrollment, time.Now().UTC())
+		if err := saveState(statePath, state); err != nil {
+			slog.Warn("Failed to save enrollment history", "error", err)
+		}
+	}
+
+	var missing []string
+	for campaign, enrolled := range enrollment {
+		if !enrolled {
+			missing = append(missing, campaign)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	sort.Strings(missing)
+	slog.Warn("Meter point is not enrolled in campaign, events will not be free for this account",
+		"campaigns", missing,
+		"meter_point", config.MeterPointID)
+
+	return errors.Wrapf(errNotEnrolled, "campaigns: %s", strings.Join(missing, ", "))
+}
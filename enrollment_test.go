@@ -0,0 +1,83 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordEnrollment(t *testing.T) {
+	day1 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	day3 := day1.AddDate(0, 0, 2)
+
+	history := recordEnrollment(nil, map[string]bool{"free_electricity": true}, day1)
+	history = recordEnrollment(history, map[string]bool{"free_electricity": true}, day2)
+
+	if len(history) != 1 {
+		t.Fatalf("Expected unchanged enrollment to extend the record, got %d records", len(history))
+	}
+	if !history[0].Since.Equal(day1) || !history[0].LastSeen.Equal(day2) {
+		t.Errorf("Unexpected record %+v", history[0])
+	}
+
+	history = recordEnrollment(history, map[string]bool{"free_electricity": false}, day3)
+
+	if len(history) != 2 {
+		t.Fatalf("Expected a new record when enrollment changes, got %d records", len(history))
+	}
+	if history[1].Enrolled || !history[1].Since.Equal(day3) {
+		t.Errorf("Unexpected record %+v", history[1])
+	}
+}
+
+func TestCheckEnrollment(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	config := testFetchConfig()
+	config.StateFile = stateFile
+
+	enrolled := []sourceResult{{name: "octopus", enrollment: map[string]bool{"free_electricity": true}}}
+	if err := checkEnrollment(config, enrolled); err != nil {
+		t.Errorf("Expected no error when enrolled, got %v", err)
+	}
+
+	notEnrolled := []sourceResult{{name: "octopus", enrollment: map[string]bool{"free_electricity": false}}}
+	err := checkEnrollment(config, notEnrolled)
+	if !errors.Is(err, errNotEnrolled) {
+		t.Errorf("Expected errNotEnrolled, got %v", err)
+	}
+
+	state, err := loadState(stateFile)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if len(state.Enrollment) != 2 || state.Enrollment[1].Enrolled {
+		t.Errorf("Expected enrollment history to record the change, got %+v", state.Enrollment)
+	}
+}
+
+func TestCheckEnrollment_NoEnrollmentReported(t *testing.T) {
+	config := testFetchConfig()
+	config.StateFile = filepath.Join(t.TempDir(), "state.json")
+
+	if err := checkEnrollment(config, []sourceResult{{name: "david_kendall"}}); err != nil {
+		t.Errorf("Expected no error without enrollment information, got %v", err)
+	}
+}
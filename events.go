@@ -36,14 +36,68 @@ type Event struct {
 	StartAt            time.Time `json:"startAt"`
 	Typename           string    `json:"__typename"`
 	IsTest             *bool     `json:"isTest,omitempty"`
+	Campaign           string    `json:"campaign,omitempty"`
+	OctoPointsPerKwh   *int      `json:"octoPointsPerKwh,omitempty"`
+	OctoPointsAwarded  *int      `json:"octoPointsAwarded,omitempty"`
+	Joined             *bool     `json:"joined,omitempty"`
+	Region             string    `json:"region,omitempty"`
+
+	// Source is the source that last reported the event and UpstreamCode
+	// the code it used, kept so moved events can be recognised
+	Source       string        `json:"-"`
+	UpstreamCode string        `json:"-"`
+	History      []EventWindow `json:"-"`
+	Cancelled    bool          `json:"-"`
+	CancelledAt  *time.Time    `json:"-"`
+	Provenance   []Provenance  `json:"-"`
+}
+
+// existingFileSource is the provenance of events that were already in the
+// output before provenance was recorded
+const existingFileSource = "existing_file"
+
+// Provenance records a source that has reported an event
+type Provenance struct {
+	Source       string
+	FirstSeen    time.Time
+	UpstreamCode string
+}
+
+// OutputProvenance is the output format of a Provenance
+type OutputProvenance struct {
+	Source       string `json:"source"`
+	FirstSeen    string `json:"first_seen"`
+	UpstreamCode string `json:"upstream_code,omitempty"`
+}
+
+// EventWindow is a window an event occupied before it was rescheduled
+type EventWindow struct {
+	StartAt time.Time
+	EndAt   time.Time
+}
+
+// OutputWindow is the output format of an EventWindow
+type OutputWindow struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
 }
 
 // OutputEvent represents the output format for events
 type OutputEvent struct {
-	Start  string `json:"start"`
-	End    string `json:"end"`
-	Code   string `json:"code"`
-	IsTest *bool  `json:"is_test,omitempty"`
+	Start             string `json:"start"`
+	End               string `json:"end"`
+	Code              string `json:"code"`
+	IsTest            *bool  `json:"is_test,omitempty"`
+	OctoPointsPerKwh  *int   `json:"octopoints_per_kwh,omitempty"`
+	OctoPointsAwarded *int   `json:"octopoints_awarded,omitempty"`
+	Joined            *bool  `json:"joined,omitempty"`
+	Region            string `json:"region,omitempty"`
+
+	History     []OutputWindow `json:"history,omitempty"`
+	Cancelled   bool           `json:"cancelled,omitempty"`
+	CancelledAt string         `json:"cancelled_at,omitempty"`
+
+	Provenance []OutputProvenance `json:"provenance,omitempty"`
 }
 
 // OutputData represents the complete output structure
@@ -79,6 +133,34 @@ type GraphQLResponse struct {
 	CustomerFlexibilityCampaignEvents       EventConnection `json:"customerFlexibilityCampaignEvents"`
 }
 
+// SavingSessionEvent represents a Saving Session announced by Octoplus
+type SavingSessionEvent struct {
+	ID                       json.Number `json:"id"`
+	Code                     string      `json:"code"`
+	StartAt                  time.Time   `json:"startAt"`
+	EndAt                    time.Time   `json:"endAt"`
+	RewardPerKwhInOctoPoints int         `json:"rewardPerKwhInOctoPoints"`
+}
+
+// SavingSessionJoinedEvent represents a Saving Session the account has joined
+type SavingSessionJoinedEvent struct {
+	EventID                 json.Number `json:"eventId"`
+	StartAt                 time.Time   `json:"startAt"`
+	EndAt                   time.Time   `json:"endAt"`
+	RewardGivenInOctoPoints *int        `json:"rewardGivenInOctoPoints"`
+}
+
+// SavingSessionsResponse represents the Octoplus saving sessions GraphQL response
+type SavingSessionsResponse struct {
+	SavingSessions struct {
+		Events  []SavingSessionEvent `json:"events"`
+		Account struct {
+			HasJoinedCampaign bool                       `json:"hasJoinedCampaign"`
+			JoinedEvents      []SavingSessionJoinedEvent `json:"joinedEvents"`
+		} `json:"account"`
+	} `json:"savingSessions"`
+}
+
 // builderPool provides a pool of string builders for efficient memory usage
 var builderPool = sync.Pool{
 	New: func() interface{} {
@@ -86,21 +168,208 @@ var builderPool = sync.Pool{
 	},
 }
 
-// assignSequentialCodes assigns sequential codes to events starting from 1
-func assignSequentialCodes(events []Event) []Event {
-	// Sort by start time to ensure consistent ordering
+// windowKey identifies an event by its start and end time
+func windowKey(event Event) string {
+	return event.StartAt.Format(time.RFC3339) + "_" + event.EndAt.Format(time.RFC3339)
+}
+
+// assignStableCodes keeps the code each event already has in the existing
+// output, matched on its window, and numbers new events in start time order
+// from the next free code so published codes never change
+func assignStableCodes(existing, events []Event) []Event {
+	codes := make(map[string]string, len(existing))
+	next := 1
+	for _, event := range existing {
+		if event.Code == "" {
+			continue
+		}
+		codes[windowKey(event)] = event.Code
+		if n, err := strconv.Atoi(event.Code); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+
 	sort.Slice(events, func(i, j int) bool {
 		return events[i].StartAt.Before(events[j].StartAt)
 	})
 
-	// Assign sequential codes starting from 1
 	for i := range events {
-		events[i].Code = strconv.Itoa(i + 1)
+		if code, ok := existingCode(codes, events[i]); ok {
+			events[i].Code = code
+			continue
+		}
+		events[i].Code = strconv.Itoa(next)
+		next++
 	}
 
 	return events
 }
 
+// existingCode finds the code of an event by its window or, for a
+// rescheduled event, by any window it occupied before
+func existingCode(codes map[string]string, event Event) (string, bool) {
+	if code, ok := codes[windowKey(event)]; ok {
+		return code, true
+	}
+	for i := len(event.History) - 1; i >= 0; i-- {
+		window := event.History[i]
+		if code, ok := codes[windowKey(Event{StartAt: window.StartAt, EndAt: window.EndAt})]; ok {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// campaignEvents returns the events belonging to a campaign. Events without a
+// campaign come from feeds that only cover free electricity sessions
+func campaignEvents(events []Event, slug string) []Event {
+	matched := make([]Event, 0, len(events))
+	for _, event := range events {
+		campaign := event.Campaign
+		if campaign == "" {
+			campaign = defaultCampaignSlug
+		}
+		if campaign == slug {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+// withSource tags events with the source that reported them, keeping the
+// source's own code as the upstream code before public codes are assigned
+func withSource(events []Event, source string, now time.Time) []Event {
+	for i := range events {
+		events[i].Source = source
+		events[i].UpstreamCode = events[i].Code
+		events[i].Provenance = []Provenance{{Source: source, FirstSeen: now, UpstreamCode: events[i].Code}}
+	}
+	return events
+}
+
+// withExistingProvenance gives events written before provenance was
+// recorded a provenance entry for the existing file
+func withExistingProvenance(events []Event, now time.Time) []Event {
+	for i, event := range events {
+		if len(event.Provenance) == 0 {
+			events[i].Provenance = []Provenance{{Source: existingFileSource, FirstSeen: now}}
+		}
+	}
+	return events
+}
+
+// reportedBy reports whether a source has ever reported the event
+func (e Event) reportedBy(source string) bool {
+	if e.Source == source {
+		return true
+	}
+	for _, p := range e.Provenance {
+		if p.Source == source {
+			return true
+		}
+	}
+	return false
+}
+
+// upstreamCode returns the code a source used for the event
+func (e Event) upstreamCode(source string) string {
+	for _, p := range e.Provenance {
+		if p.Source == source {
+			return p.UpstreamCode
+		}
+	}
+	if e.Source == source {
+		return e.UpstreamCode
+	}
+	return ""
+}
+
+// mergeProvenance combines the provenance of two reports of an event,
+// keeping the earliest sighting and latest upstream code of each source
+func mergeProvenance(previous, current []Provenance) []Provenance {
+	if len(previous) == 0 {
+		return current
+	}
+
+	merged := make([]Provenance, len(previous), len(previous)+len(current))
+	copy(merged, previous)
+	for _, p := range current {
+		found := false
+		for i := range merged {
+			if merged[i].Source != p.Source {
+				continue
+			}
+			found = true
+			if p.FirstSeen.Before(merged[i].FirstSeen) {
+				merged[i].FirstSeen = p.FirstSeen
+			}
+			if p.UpstreamCode != "" {
+				merged[i].UpstreamCode = p.UpstreamCode
+			}
+		}
+		if !found {
+			merged = append(merged, p)
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].FirstSeen.Before(merged[j].FirstSeen)
+	})
+	return merged
+}
+
+// privateCodeKey identifies a source's unpublished code for an event window
+func privateCodeKey(campaign string, event Event, source string) string {
+	return campaign + "/" + windowKey(event) + "/" + source
+}
+
+// withPrivateCodes restores the upstream codes that were kept out of the
+// output from the codes saved in the state file
+func withPrivateCodes(events []Event, campaign string, codes map[string]string) []Event {
+	for i, event := range events {
+		for j, p := range event.Provenance {
+			if p.UpstreamCode == "" {
+				events[i].Provenance[j].UpstreamCode = codes[privateCodeKey(campaign, event, p.Source)]
+			}
+		}
+	}
+	return events
+}
+
+// rememberPrivateCodes replaces the campaign's saved codes with the
+// unpublished upstream codes of its events at their current windows
+func rememberPrivateCodes(codes map[string]string, campaign string, events []Event) map[string]string {
+	prefix := campaign + "/"
+	for key := range codes {
+		if strings.HasPrefix(key, prefix) {
+			delete(codes, key)
+		}
+	}
+
+	for _, event := range events {
+		for _, p := range event.Provenance {
+			if p.UpstreamCode == "" || sourceRegistry[p.Source].publicCodes {
+				continue
+			}
+			if codes == nil {
+				codes = make(map[string]string)
+			}
+			codes[privateCodeKey(campaign, event, p.Source)] = p.UpstreamCode
+		}
+	}
+	return codes
+}
+
+// provenanceKey lists the sources of an event in a comparable form
+func provenanceKey(event Event) string {
+	sources := make([]string, 0, len(event.Provenance))
+	for _, p := range event.Provenance {
+		sources = append(sources, p.Source)
+	}
+	sort.Strings(sources)
+	return strings.Join(sources, ",")
+}
+
 // convertToOutputFormat converts internal Event format to OutputData format
 func convertToOutputFormat(events []Event) OutputData {
 	outputEvents := make([]OutputEvent, 0, len(events))
@@ -111,6 +380,33 @@ func convertToOutputFormat(events []Event) OutputData {
 			End:    event.EndAt.Format("2006-01-02T15:04:05.000Z"),
 			Code:   event.Code,
 			IsTest: event.IsTest,
+
+			OctoPointsPerKwh:  event.OctoPointsPerKwh,
+			OctoPointsAwarded: event.OctoPointsAwarded,
+			Joined:            event.Joined,
+			Region:            event.Region,
+
+			Cancelled: event.Cancelled,
+		}
+		if event.CancelledAt != nil {
+			outputEvent.CancelledAt = event.CancelledAt.UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+		for _, p := range event.Provenance {
+			provenance := OutputProvenance{
+				Source:    p.Source,
+				FirstSeen: p.FirstSeen.UTC().Format("2006-01-02T15:04:05.000Z"),
+			}
+			// Account specific codes stay in the state file
+			if sourceRegistry[p.Source].publicCodes {
+				provenance.UpstreamCode = p.UpstreamCode
+			}
+			outputEvent.Provenance = append(outputEvent.Provenance, provenance)
+		}
+		for _, window := range event.History {
+			outputEvent.History = append(outputEvent.History, OutputWindow{
+				Start: window.StartAt.Format("2006-01-02T15:04:05.000Z"),
+				End:   window.EndAt.Format("2006-01-02T15:04:05.000Z"),
+			})
 		}
 		outputEvents = append(outputEvents, outputEvent)
 	}
@@ -147,13 +443,43 @@ func loadExistingEvents(filename string) ([]Event, error) {
 			StartAt: startTime,
 			EndAt:   endTime,
 			IsTest:  outputEvent.IsTest,
+
+			OctoPointsPerKwh:  outputEvent.OctoPointsPerKwh,
+			OctoPointsAwarded: outputEvent.OctoPointsAwarded,
+			Joined:            outputEvent.Joined,
+			Region:            outputEvent.Region,
+
+			Cancelled: outputEvent.Cancelled,
+		}
+		if outputEvent.CancelledAt != "" {
+			cancelledAt, err := time.Parse("2006-01-02T15:04:05.000Z", outputEvent.CancelledAt)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse cancellation time: %w", err)
+			}
+			event.CancelledAt = &cancelledAt
+		}
+		for _, p := range outputEvent.Provenance {
+			firstSeen, err := time.Parse("2006-01-02T15:04:05.000Z", p.FirstSeen)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse provenance of event %s: %w", outputEvent.Code, err)
+			}
+			event.Provenance = append(event.Provenance, Provenance{Source: p.Source, FirstSeen: firstSeen, UpstreamCode: p.UpstreamCode})
+		}
+		for _, window := range outputEvent.History {
+			start, startErr := time.Parse("2006-01-02T15:04:05.000Z", window.Start)
+			end, endErr := time.Parse("2006-01-02T15:04:05.000Z", window.End)
+			if startErr != nil || endErr != nil {
+				return nil, fmt.Errorf("failed to parse history of event %s", outputEvent.Code)
+			}
+			event.History = append(event.History, EventWindow{StartAt: start, EndAt: end})
 		}
 		events = append(events, event)
 	}
 	return events, nil
 }
 
-// saveEvents saves events to the output file
+// saveEvents saves events to the output file. The file is replaced in one
+// step so an interrupted run never leaves it half written
 func saveEvents(events []Event, filename string) error {
 	outputData := convertToOutputFormat(events)
 	data, err := json.MarshalIndent(outputData, "", "  ")
@@ -161,5 +487,5 @@ func saveEvents(events []Event, filename string) error {
 		return err
 	}
 
-	return os.WriteFile(filename, data, 0644)
+	return writeFileAtomic(filename, data, 0644)
 }
@@ -17,6 +17,9 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -24,14 +27,14 @@ import (
 	"time"
 )
 
-func TestAssignSequentialCodes(t *testing.T) {
+func TestAssignStableCodes(t *testing.T) {
 	events := []Event{
 		{StartAt: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
 		{StartAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
 		{StartAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
 	}
 
-	result := assignSequentialCodes(events)
+	result := assignStableCodes(nil, events)
 
 	// Should be sorted by start time and assigned sequential codes
 	expected := []string{"1", "2", "3"}
@@ -47,6 +50,31 @@ func TestAssignSequentialCodes(t *testing.T) {
 	}
 }
 
+func TestAssignStableCodes_PreservesExisting(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC) }
+	existing := []Event{
+		{Code: "1", StartAt: day(2), EndAt: day(2).Add(time.Hour)},
+		{Code: "2", StartAt: day(4), EndAt: day(4).Add(time.Hour)},
+	}
+
+	// A backfilled event earlier than both, an upstream copy of an existing
+	// event and a new upcoming event
+	events := []Event{
+		{Code: "OCTO-9", StartAt: day(4), EndAt: day(4).Add(time.Hour)},
+		{Code: "1", StartAt: day(2), EndAt: day(2).Add(time.Hour)},
+		{Code: "OCTO-1", StartAt: day(1), EndAt: day(1).Add(time.Hour)},
+		{Code: "OCTO-10", StartAt: day(5), EndAt: day(5).Add(time.Hour)},
+	}
+
+	result := assignStableCodes(existing, events)
+
+	expected := []string{"3", "1", "2", "4"}
+	actual := []string{result[0].Code, result[1].Code, result[2].Code, result[3].Code}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected codes %v, got %v", expected, actual)
+	}
+}
+
 func TestConvertToOutputFormat(t *testing.T) {
 	events := []Event{
 		{
@@ -133,6 +161,21 @@ func TestHasChanges(t *testing.T) {
 	if !hasChanges([]Event{event1}, []Event{event3}) {
 		t.Error("Expected changes for different events")
 	}
+
+	// Test with the same window but an updated field
+	awarded := 1600
+	event4 := event1
+	event4.OctoPointsAwarded = &awarded
+	if !hasChanges([]Event{event1}, []Event{event4}) {
+		t.Error("Expected changes when a field of an existing event is updated")
+	}
+
+	// Codes are assigned after merging, so they do not count
+	event5 := event1
+	event5.Code = "SESSION-1"
+	if hasChanges([]Event{event1}, []Event{event5}) {
+		t.Error("Expected code differences to be ignored")
+	}
 }
 
 func TestMergeEvents(t *testing.T) {
@@ -153,6 +196,320 @@ func TestMergeEvents(t *testing.T) {
 	}
 }
 
+func TestReconcileRescheduled(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2024, 1, 1, hour, 0, 0, 0, time.UTC) }
+	existing := []Event{
+		{Code: "1", Source: "octopus", UpstreamCode: "E1", StartAt: at(10), EndAt: at(11)},
+		{Code: "2", Source: "octopus", UpstreamCode: "E2", StartAt: at(14), EndAt: at(15)},
+		{Code: "3", Source: "david_kendall", UpstreamCode: "7", StartAt: at(18), EndAt: at(19)},
+	}
+
+	t.Run("same upstream code", func(t *testing.T) {
+		fetched := withSource([]Event{
+			{Code: "E1", StartAt: at(16), EndAt: at(17)},
+			{Code: "E2", StartAt: at(14), EndAt: at(15)},
+		}, "octopus", at(0))
+
+		result, _ := reconcileRescheduled(existing, fetched, time.Hour)
+
+		if !result[0].StartAt.Equal(at(16)) || len(result[0].History) != 1 || !result[0].History[0].StartAt.Equal(at(10)) {
+			t.Errorf("Expected E1 to move to 16:00 with its old window in history, got %+v", result[0])
+		}
+		if len(result[1].History) != 0 {
+			t.Errorf("Expected event still reported to be left alone, got %+v", result[1])
+		}
+		if existing[0].StartAt.Equal(at(16)) {
+			t.Error("Expected existing events not to be modified")
+		}
+	})
+
+	t.Run("different upstream code", func(t *testing.T) {
+		fetched := withSource([]Event{{Code: "E9", StartAt: at(10).Add(30 * time.Minute), EndAt: at(11)}}, "octopus", at(0))
+
+		result, _ := reconcileRescheduled(existing, fetched, time.Hour)
+
+		if len(result[0].History) != 0 {
+			t.Errorf("Expected a new Octopus code not to replace an overlapping event, got %+v", result[0])
+		}
+	})
+
+	t.Run("overlapping window", func(t *testing.T) {
+		fetched := withSource([]Event{{Code: "8", StartAt: at(19), EndAt: at(20)}}, "david_kendall", at(0))
+
+		result, _ := reconcileRescheduled(existing, fetched, time.Hour)
+		if !result[2].StartAt.Equal(at(19)) || len(result[2].History) != 1 {
+			t.Errorf("Expected feed event moved by an hour to be reconciled, got %+v", result[2])
+		}
+
+		result, _ = reconcileRescheduled(existing, fetched, -1)
+		if len(result[2].History) != 0 {
+			t.Errorf("Expected negative tolerance to disable overlap matching, got %+v", result[2])
+		}
+	})
+
+	t.Run("last written by another source", func(t *testing.T) {
+		// The feed still lists the old window and is merged before Octopus
+		run := func(events []Event, feed, octopus Event) []Event {
+			for _, fetched := range [][]Event{
+				withSource([]Event{feed}, "david_kendall", at(0)),
+				withSource([]Event{octopus}, "octopus", at(0)),
+			} {
+				events, fetched = reconcileRescheduled(events, fetched, time.Hour)
+				events = mergeEvents(events, fetched)
+			}
+			return events
+		}
+		shared := []Event{{
+			Code: "1", Source: "david_kendall", UpstreamCode: "7", StartAt: at(12), EndAt: at(13),
+			Provenance: []Provenance{
+				{Source: "david_kendall", FirstSeen: at(0), UpstreamCode: "7"},
+				{Source: "octopus", FirstSeen: at(0), UpstreamCode: "OCT-1"},
+			},
+		}}
+		moved := Event{Code: "OCT-1", StartAt: at(14), EndAt: at(15)}
+
+		events := run(shared, Event{Code: "7", StartAt: at(12), EndAt: at(13)}, moved)
+		if len(events) != 1 || !events[0].StartAt.Equal(at(14)) || len(events[0].History) != 1 {
+			t.Fatalf("Expected OCT-1 to move to 14:00 while the feed lists the old window, got %+v", events)
+		}
+
+		events = run(events, Event{Code: "7", StartAt: at(12), EndAt: at(13)}, moved)
+		if len(events) != 1 {
+			t.Fatalf("Expected the feed's stale window not to come back, got %+v", events)
+		}
+
+		events = run(events, Event{Code: "7", StartAt: at(14), EndAt: at(15)}, moved)
+		if len(events) != 1 || !events[0].StartAt.Equal(at(14)) {
+			t.Errorf("Expected one event once the feed catches up, got %+v", events)
+		}
+	})
+}
+
+func TestUpdateCampaign_PersistsRewardUpdate(t *testing.T) {
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "saving_sessions.json")
+	config := &Config{StateFile: filepath.Join(dir, "state.json")}
+	campaign := CampaignConfig{Slug: savingSessionsCampaign, OutputFile: outputFile}
+
+	start := time.Date(2025, 1, 10, 17, 0, 0, 0, time.UTC)
+	session := Event{Code: "S1", Campaign: savingSessionsCampaign, StartAt: start, EndAt: start.Add(time.Hour), Joined: boolPtr(true)}
+	run := func(event Event) {
+		results := []sourceResult{{name: "saving_sessions", events: []Event{event}}}
+		if err := updateCampaign(context.Background(), config, campaign, results); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	run(session)
+
+	// The reward is only known once the session has been settled
+	awarded := 1600
+	session.OctoPointsAwarded = &awarded
+	run(session)
+
+	events, err := loadExistingEvents(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to load events: %v", err)
+	}
+	if len(events) != 1 || events[0].OctoPointsAwarded == nil || *events[0].OctoPointsAwarded != awarded {
+		t.Errorf("Expected the awarded points to be written, got %+v", events)
+	}
+}
+
+func TestUpdateCampaign_RescheduledEventKeepsCode(t *testing.T) {
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "output.json")
+	config := &Config{StateFile: filepath.Join(dir, "state.json"), Reconcile: ReconcileConfig{Tolerance: -1}}
+	campaign := CampaignConfig{Slug: defaultCampaignSlug, OutputFile: outputFile}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	run := func(events ...Event) {
+		results := []sourceResult{{name: "octopus", events: events}}
+		if err := updateCampaign(context.Background(), config, campaign, results); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	run(
+		Event{Code: "E1", StartAt: start, EndAt: start.Add(time.Hour)},
+		Event{Code: "E2", StartAt: start.Add(24 * time.Hour), EndAt: start.Add(25 * time.Hour)},
+	)
+
+	// The Octopus codes are only kept in the state file
+	data, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if bytes.Contains(data, []byte("E1")) {
+		t.Errorf("Expected the Octopus code to stay out of the output, got %s", data)
+	}
+
+	// Matching on codes alone, the event moved three hours
+	run(
+		Event{Code: "E1", StartAt: start.Add(3 * time.Hour), EndAt: start.Add(4 * time.Hour)},
+		Event{Code: "E2", StartAt: start.Add(24 * time.Hour), EndAt: start.Add(25 * time.Hour)},
+	)
+
+	events, err := loadExistingEvents(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to load events: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected the moved event to replace its old window, got %d events", len(events))
+	}
+	moved := events[0]
+	if moved.Code != "1" || !moved.StartAt.Equal(start.Add(3*time.Hour)) {
+		t.Errorf("Expected event 1 to keep its code at the new time, got %+v", moved)
+	}
+	if len(moved.History) != 1 || !moved.History[0].StartAt.Equal(start) {
+		t.Errorf("Expected the previous window in history, got %+v", moved.History)
+	}
+}
+
+func TestUpdateCampaign_CorruptState(t *testing.T) {
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "output.json")
+	stateFile := filepath.Join(dir, "state.json")
+	// A run killed while writing the state file left it truncated
+	if err := os.WriteFile(stateFile, []byte(`{"missing": {`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{StateFile: stateFile}
+	campaign := CampaignConfig{Slug: defaultCampaignSlug, OutputFile: outputFile}
+	start := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Hour)
+	results := []sourceResult{{name: "octopus", events: []Event{{Code: "E1", StartAt: start, EndAt: start.Add(time.Hour)}}}}
+
+	if err := updateCampaign(context.Background(), config, campaign, results); err != nil {
+		t.Fatalf("Expected unreadable state not to block the feed, got %v", err)
+	}
+
+	events, err := loadExistingEvents(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to load events: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected the feed to be updated, got %d events", len(events))
+	}
+	if data, _ := os.ReadFile(stateFile); string(data) != `{"missing": {` {
+		t.Errorf("Expected unreadable state to be left alone, got %s", data)
+	}
+}
+
+func TestTrackCancellations(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := Event{Code: "1", Source: "octopus", StartAt: now.Add(48 * time.Hour), EndAt: now.Add(49 * time.Hour)}
+	past := Event{Code: "2", Source: "octopus", StartAt: now.Add(-48 * time.Hour), EndAt: now.Add(-47 * time.Hour)}
+	existing := []Event{future, past}
+	missing := make(map[string]int)
+
+	run := func(results []sourceResult) []Event {
+		merged := append([]Event(nil), existing...)
+		return trackCancellations(defaultCampaignSlug, existing, merged, results, missing, 2, now)
+	}
+	absent := []sourceResult{{name: "octopus"}}
+
+	if result := run(absent); result[0].Cancelled {
+		t.Fatal("Expected event not to be cancelled after one missed run")
+	}
+	if result := run([]sourceResult{{name: "octopus", err: errors.New("unavailable")}}); result[0].Cancelled {
+		t.Fatal("Expected a failed fetch not to count as a missed run")
+	}
+	result := run(absent)
+	if !result[0].Cancelled || result[0].CancelledAt == nil || !result[0].CancelledAt.Equal(now) {
+		t.Errorf("Expected event to be cancelled after two missed runs, got %+v", result[0])
+	}
+	if result[1].Cancelled {
+		t.Error("Expected past events never to be cancelled")
+	}
+	if len(result) != len(existing) {
+		t.Errorf("Expected cancelled events to be kept, got %d events", len(result))
+	}
+
+	// Reporting the event again resets its count
+	missing = map[string]int{defaultCampaignSlug + "/" + windowKey(future): 1}
+	run([]sourceResult{{name: "octopus", events: []Event{future}}})
+	if len(missing) != 0 {
+		t.Errorf("Expected count to be cleared once the event is reported, got %v", missing)
+	}
+}
+
+func TestTrackCancellations_CampaignFailed(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := Event{Code: "1", Source: "octopus", StartAt: now.Add(48 * time.Hour), EndAt: now.Add(49 * time.Hour)}
+	missing := make(map[string]int)
+
+	// Octopus answered, but not for this campaign
+	results := []sourceResult{{name: "octopus", campaignErrs: map[string]error{
+		defaultCampaignSlug: errors.New("campaign not found"),
+	}}}
+	trackCancellations(defaultCampaignSlug, []Event{future}, []Event{future}, results, missing, 1, now)
+
+	if len(missing) != 0 {
+		t.Errorf("Expected no missed runs while the campaign failed, got %v", missing)
+	}
+}
+
+func TestTrackCancellations_NotEnrolled(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := Event{Code: "1", Source: "octopus", StartAt: now.Add(48 * time.Hour), EndAt: now.Add(49 * time.Hour)}
+	key := defaultCampaignSlug + "/" + windowKey(future)
+	missing := map[string]int{key: 1}
+
+	// The account dropped out of the campaign, so its listing is empty
+	results := []sourceResult{{name: "octopus", enrollment: map[string]bool{defaultCampaignSlug: false}}}
+	for i := 0; i < 3; i++ {
+		result := trackCancellations(defaultCampaignSlug, []Event{future}, []Event{future}, results, missing, 2, now)
+		if result[0].Cancelled {
+			t.Fatal("Expected event not to be cancelled while the account is not enrolled")
+		}
+	}
+	if missing[key] != 1 {
+		t.Errorf("Expected missed count to stay at 1, got %d", missing[key])
+	}
+}
+
+func TestTrackCancellations_OtherSourceUp(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := Event{Code: "1", Source: "octopus", StartAt: now.Add(48 * time.Hour), EndAt: now.Add(49 * time.Hour)}
+	key := defaultCampaignSlug + "/" + windowKey(future)
+	missing := map[string]int{key: 1}
+
+	// Octopus is down while Saving Sessions answers for its own campaign
+	results := []sourceResult{
+		{name: "octopus", err: errors.New("unavailable")},
+		{name: "saving_sessions"},
+	}
+	for i := 0; i < 3; i++ {
+		result := trackCancellations(defaultCampaignSlug, []Event{future}, []Event{future}, results, missing, 2, now)
+		if result[0].Cancelled {
+			t.Fatal("Expected event not to be cancelled while its own source is down")
+		}
+	}
+	if missing[key] != 1 {
+		t.Errorf("Expected missed count to stay at 1, got %d", missing[key])
+	}
+}
+
+func TestMergeEvents_KeepsCancellation(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	cancelledAt := start.Add(-time.Hour)
+	cancelled := Event{Source: "octopus", StartAt: start, EndAt: start.Add(time.Hour), Cancelled: true, CancelledAt: &cancelledAt}
+
+	feed := Event{Source: "david_kendall", StartAt: start, EndAt: start.Add(time.Hour)}
+	if result := mergeEvents([]Event{cancelled}, []Event{feed}); !result[0].Cancelled {
+		t.Error("Expected a non-authoritative source not to undo a cancellation")
+	}
+
+	octopus := Event{Source: "octopus", StartAt: start, EndAt: start.Add(time.Hour)}
+	if result := mergeEvents([]Event{cancelled}, []Event{octopus}); result[0].Cancelled {
+		t.Error("Expected the authoritative source to bring a cancelled event back")
+	}
+
+	if !hasChanges([]Event{octopus}, []Event{cancelled}) {
+		t.Error("Expected a cancellation to count as a change")
+	}
+}
+
 func TestSaveEvents(t *testing.T) {
 	tempDir := t.TempDir()
 	testFile := filepath.Join(tempDir, "output.json")
@@ -192,7 +549,108 @@ func TestSaveEvents(t *testing.T) {
 	}
 }
 
+func TestSaveEvents_RewardFieldsRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "saving_sessions.json")
+
+	points, awarded := 800, 1600
+	events := []Event{
+		{
+			Code:              "1",
+			StartAt:           time.Date(2025, 1, 10, 17, 0, 0, 0, time.UTC),
+			EndAt:             time.Date(2025, 1, 10, 18, 0, 0, 0, time.UTC),
+			OctoPointsPerKwh:  &points,
+			OctoPointsAwarded: &awarded,
+			Joined:            boolPtr(true),
+		},
+	}
+
+	if err := saveEvents(events, testFile); err != nil {
+		t.Fatalf("Failed to save events: %v", err)
+	}
+
+	loaded, err := loadExistingEvents(testFile)
+	if err != nil {
+		t.Fatalf("Failed to load saved events: %v", err)
+	}
+
+	if len(loaded) != 1 {
+		t.Fatalf("Expected 1 loaded event, got %d", len(loaded))
+	}
+	if !reflect.DeepEqual(loaded[0].OctoPointsPerKwh, &points) ||
+		!reflect.DeepEqual(loaded[0].OctoPointsAwarded, &awarded) ||
+		!reflect.DeepEqual(loaded[0].Joined, boolPtr(true)) {
+		t.Errorf("Reward fields did not survive a round trip: %+v", loaded[0])
+	}
+}
+
 // Helper function to create bool pointer
 func boolPtr(b bool) *bool {
 	return &b
 }
+
+func TestSaveEvents_ReconciliationFieldsRoundTrip(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "output.json")
+
+	start := time.Date(2025, 1, 10, 17, 0, 0, 0, time.UTC)
+	cancelledAt := start.Add(-24 * time.Hour)
+	events := []Event{
+		{
+			Code:        "1",
+			StartAt:     start,
+			EndAt:       start.Add(time.Hour),
+			History:     []EventWindow{{StartAt: start.Add(-time.Hour), EndAt: start}},
+			Cancelled:   true,
+			CancelledAt: &cancelledAt,
+			Provenance: []Provenance{
+				{Source: "david_kendall", FirstSeen: cancelledAt.Add(-time.Hour), UpstreamCode: "4"},
+				{Source: "octopus", FirstSeen: cancelledAt},
+			},
+		},
+	}
+
+	if err := saveEvents(events, testFile); err != nil {
+		t.Fatalf("Failed to save events: %v", err)
+	}
+
+	loaded, err := loadExistingEvents(testFile)
+	if err != nil {
+		t.Fatalf("Failed to load events: %v", err)
+	}
+	if !reflect.DeepEqual(events, loaded) {
+		t.Errorf("Expected %+v, got %+v", events, loaded)
+	}
+}
+
+func TestMergeEvents_Provenance(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	day1, day2 := start.Add(-48*time.Hour), start.Add(-24*time.Hour)
+
+	existing := withSource([]Event{{Code: "4", StartAt: start, EndAt: start.Add(time.Hour)}}, "david_kendall", day1)
+	merged := mergeEvents(existing, withSource([]Event{{Code: "4", StartAt: start, EndAt: start.Add(time.Hour)}}, "david_kendall", day2))
+	merged = mergeEvents(merged, withSource([]Event{{Code: "E1", StartAt: start, EndAt: start.Add(time.Hour)}}, "octopus", day2))
+
+	expected := []Provenance{
+		{Source: "david_kendall", FirstSeen: day1, UpstreamCode: "4"},
+		{Source: "octopus", FirstSeen: day2, UpstreamCode: "E1"},
+	}
+	if !reflect.DeepEqual(merged[0].Provenance, expected) {
+		t.Errorf("Expected provenance %+v, got %+v", expected, merged[0].Provenance)
+	}
+	if !hasChanges(existing, merged) {
+		t.Error("Expected confirmation by another source to count as a change")
+	}
+}
+
+func TestWithExistingProvenance(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	known := []Provenance{{Source: "octopus", FirstSeen: now.Add(-time.Hour), UpstreamCode: "E2"}}
+	events := withExistingProvenance([]Event{{Code: "1"}, {Code: "2", Provenance: known}}, now)
+
+	if events[0].Provenance[0].Source != existingFileSource {
+		t.Errorf("Expected legacy event to come from the existing file, got %+v", events[0].Provenance)
+	}
+	if !reflect.DeepEqual(events[1].Provenance, known) {
+		t.Errorf("Expected event with provenance to keep it, got %+v", events[1].Provenance)
+	}
+}
@@ -22,96 +22,446 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/machinebox/graphql"
 	"github.com/pkg/errors"
 )
 
-// fetchOctopusEvents fetches events from the Octopus Energy GraphQL API
-func fetchOctopusEvents(config *Config) ([]Event, error) {
-	client := NewAuthenticatedClient(config.APIKey, graphqlEndpoint)
-
-	query := `
-		query getFreeElectricityEnrollmentAndEvents($accountNumber: String!, $meterPointId: String!, $campaignSlug: String!) {
-			isEnrolledInCustomerFlexibilityCampaign(
-				accountNumber: $accountNumber
-				campaignSlug: $campaignSlug
-				supplyPointIdentifier: $meterPointId
-			)
-			customerFlexibilityCampaignEvents(
-				accountNumber: $accountNumber
-				campaignSlug: $campaignSlug
-				supplyPointIdentifier: $meterPointId
-				first: 20
-			) {
-				edges {
-					cursor
-					node {
-						code
-						endAt
-						isEventParticipant
-						name
-						startAt
-						__typename
-					}
-					__typename
-				}
-				pageInfo {
-					endCursor
-					hasNextPage
-					hasPreviousPage
-					startCursor
+// octopusSource fetches events from the Octopus Energy GraphQL API
+type octopusSource struct {
+	config       *Config
+	client       *AuthenticatedClient
+	priority     int
+	enrollment   map[string]bool
+	campaignErrs map[string]error
+}
+
+func newOctopusSource(config *Config, clients sourceClients, priority int) EventSource {
+	return &octopusSource{config: config, client: clients.kraken, priority: priority}
+}
+
+func (s *octopusSource) Name() string { return "octopus" }
+
+func (s *octopusSource) Priority() int { return s.priority }
+
+func (s *octopusSource) Fetch(ctx context.Context) ([]Event, error) {
+	events, enrollment, campaignErrs, err := fetchOctopusEventsWithClient(ctx, s.client, s.config)
+	if err != nil {
+		return nil, err
+	}
+	s.enrollment = enrollment
+	s.campaignErrs = campaignErrs
+	return events, nil
+}
+
+// Enrollment reports whether the meter point is enrolled in each campaign
+func (s *octopusSource) Enrollment() map[string]bool { return s.enrollment }
+
+// CampaignErrors reports the campaigns whose events could not be fetched
+func (s *octopusSource) CampaignErrors() map[string]error { return s.campaignErrs }
+
+// davidKendallSource fetches historical events from David Kendall's API
+type davidKendallSource struct {
+	config   *Config
+	client   *http.Client
+	priority int
+}
+
+func newDavidKendallSource(config *Config, clients sourceClients, priority int) EventSource {
+	return &davidKendallSource{config: config, client: clients.http, priority: priority}
+}
+
+func (s *davidKendallSource) Name() string { return "david_kendall" }
+
+func (s *davidKendallSource) Priority() int { return s.priority }
+
+func (s *davidKendallSource) Fetch(ctx context.Context) ([]Event, error) {
+	// A recording must hold the full response and a replay must not touch
+	// the live cache, so neither uses it
+	dir := cacheDir
+	if s.config.RecordDir != "" || s.config.ReplayDir != "" {
+		dir = ""
+	}
+	return fetchDavidKendallData(ctx, s.client, s.config.Endpoints.DavidKendall, newRetryPolicy(s.config.Retry), dir)
+}
+
+// savingSessionsSource fetches Octoplus Saving Sessions for the account
+type savingSessionsSource struct {
+	config   *Config
+	client   *AuthenticatedClient
+	priority int
+}
+
+func newSavingSessionsSource(config *Config, clients sourceClients, priority int) EventSource {
+	return &savingSessionsSource{config: config, client: clients.kraken, priority: priority}
+}
+
+func (s *savingSessionsSource) Name() string { return "saving_sessions" }
+
+func (s *savingSessionsSource) Priority() int { return s.priority }
+
+func (s *savingSessionsSource) Fetch(ctx context.Context) ([]Event, error) {
+	return fetchSavingSessionsWithClient(ctx, s.client, s.config)
+}
+
+const (
+	// octopusPageSize is the number of events requested per GraphQL page
+	octopusPageSize = 20
+	// maxOctopusPages caps pagination in case the API never reports the last page
+	maxOctopusPages = 50
+)
+
+// campaignEventsFields selects everything we decode from an event connection
+const campaignEventsFields = `{
+			edges {
+				cursor
+				node {
+					code
+					endAt
+					isEventParticipant
+					name
+					startAt
 					__typename
 				}
-				totalCount
-				edgeCount
 				__typename
 			}
+			pageInfo {
+				endCursor
+				hasNextPage
+				hasPreviousPage
+				startCursor
+				__typename
+			}
+			totalCount
+			edgeCount
+			__typename
+		}`
+
+// buildCampaignEventsQuery builds a single query fetching enrollment and one
+// page of events for each campaign index, using aliases such as enrolled0 and
+// events0 so several campaigns can be requested at once
+func buildCampaignEventsQuery(indexes []int, backwards bool) string {
+	sizeArg, cursorArg := "first", "after"
+	if backwards {
+		sizeArg, cursorArg = "last", "before"
+	}
+
+	var b strings.Builder
+	b.WriteString("\n\tquery getFlexibilityCampaignEnrollmentAndEvents($accountNumber: String!, $meterPointId: String!, $pageSize: Int!")
+	for _, i := range indexes {
+		fmt.Fprintf(&b, ", $slug%d: String!, $cursor%d: String", i, i)
+	}
+	b.WriteString(") {\n")
+
+	for _, i := range indexes {
+		fmt.Fprintf(&b, `		enrolled%d: isEnrolledInCustomerFlexibilityCampaign(
+			accountNumber: $accountNumber
+			campaignSlug: $slug%d
+			supplyPointIdentifier: $meterPointId
+		)
+		events%d: customerFlexibilityCampaignEvents(
+			accountNumber: $accountNumber
+			campaignSlug: $slug%d
+			supplyPointIdentifier: $meterPointId
+			%s: $pageSize
+			%s: $cursor%d
+		) %s
+`, i, i, i, i, sizeArg, cursorArg, i, campaignEventsFields)
+	}
+
+	b.WriteString("\t}\n")
+	return b.String()
+}
+
+// decodeCampaignResponse extracts the aliased fields for one campaign
+func decodeCampaignResponse(data map[string]json.RawMessage, index int) (GraphQLResponse, error) {
+	var response GraphQLResponse
+
+	raw, ok := data[fmt.Sprintf("events%d", index)]
+	if !ok {
+		return response, fmt.Errorf("response is missing events for campaign %d", index)
+	}
+	if err := json.Unmarshal(raw, &response.CustomerFlexibilityCampaignEvents); err != nil {
+		return response, errors.Wrap(err, "failed to decode campaign events")
+	}
+
+	if raw, ok := data[fmt.Sprintf("enrolled%d", index)]; ok {
+		if err := json.Unmarshal(raw, &response.IsEnrolledInCustomerFlexibilityCampaign); err != nil {
+			return response, errors.Wrap(err, "failed to decode campaign enrollment")
+		}
+	}
+
+	return response, nil
+}
+
+// isCampaignField reports whether a field is one of the aliases requested for
+// the given campaign indexes
+func isCampaignField(field string, indexes []int) bool {
+	for _, i := range indexes {
+		if field == fmt.Sprintf("events%d", i) || field == fmt.Sprintf("enrolled%d", i) {
+			return true
+		}
+	}
+	return false
+}
+
+// newOctopusClient creates a client for the Octopus Energy GraphQL API that
+// retries transient failures, persisting tokens between runs when the token
+// cache is enabled. Later options override the defaults
+func newOctopusClient(config *Config, httpClient *http.Client, extra ...ClientOption) *AuthenticatedClient {
+	opts := []ClientOption{WithHTTPClient(httpClient), WithRetryPolicy(newRetryPolicy(config.Retry))}
+	if config.TokenCache {
+		opts = append(opts, WithTokenStore(newTokenStore(cacheDir)))
+	}
+	return NewAuthenticatedClient(config.APIKey, config.Endpoints.GraphQL, append(opts, extra...)...)
+}
+
+// fetchOctopusEventsWithClient fetches every page of events and campaign
+// enrollment using the given client
+func fetchOctopusEventsWithClient(ctx context.Context, client *AuthenticatedClient, config *Config) ([]Event, map[string]bool, map[string]error, error) {
+	return walkOctopusEvents(ctx, client, config, false)
+}
+
+// fetchOctopusHistory fetches the complete campaign history, newest page first
+func fetchOctopusHistory(ctx context.Context, config *Config, httpClient *http.Client) ([]Event, map[string]error, error) {
+	client := newOctopusClient(config, httpClient)
+	return fetchOctopusHistoryWithClient(ctx, client, config)
+}
+
+// fetchOctopusHistoryWithClient walks the event connection backwards using the given client
+func fetchOctopusHistoryWithClient(ctx context.Context, client *AuthenticatedClient, config *Config) ([]Event, map[string]error, error) {
+	events, _, campaignErrs, err := walkOctopusEvents(ctx, client, config, true)
+	return events, campaignErrs, err
+}
+
+// walkOctopusEvents pages through the event connection of every enabled
+// campaign in a single request per page, forwards using first/after or
+// backwards using last/before. Each campaign stops independently once the API
+// reports no more pages, its cursor stops advancing or the page cap is reached.
+// Enrollment for each campaign slug is taken from the first page. Errors
+// confined to one campaign's fields stop that campaign and are returned by
+// slug, keeping the events of the others; the walk only fails outright when
+// every campaign does
+func walkOctopusEvents(ctx context.Context, client *AuthenticatedClient, config *Config, backwards bool) ([]Event, map[string]bool, map[string]error, error) {
+	campaigns := enabledCampaigns(config)
+	enrollment := make(map[string]bool, len(campaigns))
+	campaignErrs := make(map[string]error)
+	if len(campaigns) == 0 {
+		return []Event{}, enrollment, campaignErrs, nil
+	}
+
+	events := []Event{}
+	cursors := make([]string, len(campaigns))
+	seenCursors := make([]map[string]bool, len(campaigns))
+	pending := make([]int, len(campaigns))
+	for i := range campaigns {
+		pending[i] = i
+		seenCursors[i] = make(map[string]bool)
+	}
+
+	for page := 1; len(pending) > 0; page++ {
+		req := graphql.NewRequest(buildCampaignEventsQuery(pending, backwards))
+		req.Var("accountNumber", config.AccountNumber)
+		req.Var("meterPointId", config.MeterPointID)
+		req.Var("pageSize", octopusPageSize)
+		for _, i := range pending {
+			req.Var(fmt.Sprintf("slug%d", i), campaigns[i].Slug)
+			if cursors[i] != "" {
+				req.Var(fmt.Sprintf("cursor%d", i), cursors[i])
+			}
+		}
+
+		var data map[string]json.RawMessage
+		var partial []GraphQLErrorDetail
+		if err := client.Run(withPartialErrors(ctx, &partial), req, &data); err != nil {
+			return nil, nil, nil, errors.Wrapf(err, "failed to execute GraphQL query (page %d)", page)
+		}
+
+		failed := fieldErrors(partial)
+		for field, details := range failed {
+			if !isCampaignField(field, pending) {
+				return nil, nil, nil, errors.Wrapf(newFieldError(details), "failed to execute GraphQL query (page %d)", page)
+			}
+		}
+
+		var stillPending []int
+		for _, i := range pending {
+			slug := campaigns[i].Slug
+
+			if details := failed[fmt.Sprintf("events%d", i)]; len(details) > 0 {
+				err := newFieldError(details)
+				slog.Warn("Failed to fetch campaign events", "campaign", slug, "page", page, "error", err)
+				campaignErrs[slug] = errors.Wrapf(err, "campaign %s (page %d)", slug, page)
+				continue
+			}
+
+			response, err := decodeCampaignResponse(data, i)
+			if err != nil {
+				return nil, nil, nil, errors.Wrapf(err, "campaign %s (page %d)", slug, page)
+			}
+			if page == 1 {
+				if details := failed[fmt.Sprintf("enrolled%d", i)]; len(details) > 0 {
+					slog.Warn("Failed to check campaign enrollment", "campaign", slug, "error", newFieldError(details))
+				} else {
+					enrollment[slug] = response.IsEnrolledInCustomerFlexibilityCampaign
+				}
+			}
+
+			connection := response.CustomerFlexibilityCampaignEvents
+			for _, edge := range connection.Edges {
+				event := edge.Node
+				event.Campaign = slug
+				events = append(events, event)
+			}
+
+			hasMore, next := connection.PageInfo.HasNextPage, connection.PageInfo.EndCursor
+			if backwards {
+				hasMore, next = connection.PageInfo.HasPreviousPage, connection.PageInfo.StartCursor
+			}
+			if !hasMore {
+				continue
+			}
+
+			if next == "" || seenCursors[i][next] {
+				slog.Warn("Stopping Octopus pagination, cursor did not advance",
+					"campaign", slug, "page", page, "cursor", next, "backwards", backwards)
+				continue
+			}
+
+			if page >= maxOctopusPages {
+				slog.Warn("Stopping Octopus pagination, page limit reached",
+					"campaign", slug, "pages", page, "total_count", connection.TotalCount, "backwards", backwards)
+				continue
+			}
+
+			seenCursors[i][next] = true
+			cursors[i] = next
+			stillPending = append(stillPending, i)
+		}
+
+		pending = stillPending
+	}
+
+	if len(campaignErrs) == len(campaigns) {
+		return nil, nil, nil, campaignErrs[campaigns[0].Slug]
+	}
+
+	return events, enrollment, campaignErrs, nil
+}
+
+const savingSessionsQuery = `
+	query getSavingSessions($accountNumber: String!) {
+		savingSessions {
+			events(getDevEvents: false) {
+				id
+				code
+				startAt
+				endAt
+				rewardPerKwhInOctoPoints
+			}
+			account(accountNumber: $accountNumber) {
+				hasJoinedCampaign
+				joinedEvents {
+					eventId
+					startAt
+					endAt
+					rewardGivenInOctoPoints
+				}
+			}
 		}
-	`
+	}
+`
 
-	req := graphql.NewRequest(query)
+// fetchSavingSessionsWithClient fetches Saving Sessions using the given client,
+// marking the sessions the account has joined along with any points awarded
+func fetchSavingSessionsWithClient(ctx context.Context, client *AuthenticatedClient, config *Config) ([]Event, error) {
+	req := graphql.NewRequest(savingSessionsQuery)
 	req.Var("accountNumber", config.AccountNumber)
-	req.Var("meterPointId", config.MeterPointID)
-	req.Var("campaignSlug", "free_electricity")
 
-	var response GraphQLResponse
-	if err := client.Run(context.Background(), req, &response); err != nil {
-		return nil, errors.Wrap(err, "failed to execute GraphQL query")
+	var response SavingSessionsResponse
+	if err := client.Run(ctx, req, &response); err != nil {
+		return nil, errors.Wrap(err, "failed to execute saving sessions query")
+	}
+
+	account := response.SavingSessions.Account
+	if !account.HasJoinedCampaign {
+		slog.Warn("Account has not joined Saving Sessions", "account", config.AccountNumber)
 	}
 
-	events := make([]Event, 0, len(response.CustomerFlexibilityCampaignEvents.Edges))
-	for _, edge := range response.CustomerFlexibilityCampaignEvents.Edges {
-		events = append(events, edge.Node)
+	joined := make(map[string]SavingSessionJoinedEvent, len(account.JoinedEvents))
+	for _, joinedEvent := range account.JoinedEvents {
+		joined[joinedEvent.EventID.String()] = joinedEvent
+	}
+
+	events := make([]Event, 0, len(response.SavingSessions.Events)+len(joined))
+	for _, session := range response.SavingSessions.Events {
+		pointsPerKwh := session.RewardPerKwhInOctoPoints
+		joinedEvent, hasJoined := joined[session.ID.String()]
+		delete(joined, session.ID.String())
+
+		event := Event{
+			Code:             session.Code,
+			StartAt:          session.StartAt,
+			EndAt:            session.EndAt,
+			Campaign:         savingSessionsCampaign,
+			OctoPointsPerKwh: &pointsPerKwh,
+			Joined:           &hasJoined,
+		}
+		if hasJoined {
+			event.OctoPointsAwarded = joinedEvent.RewardGivenInOctoPoints
+		}
+		events = append(events, event)
+	}
+
+	// Older joined sessions drop out of the events list but still carry rewards
+	for _, joinedEvent := range account.JoinedEvents {
+		if _, pending := joined[joinedEvent.EventID.String()]; !pending {
+			continue
+		}
+
+		hasJoined := true
+		events = append(events, Event{
+			Code:              joinedEvent.EventID.String(),
+			StartAt:           joinedEvent.StartAt,
+			EndAt:             joinedEvent.EndAt,
+			Campaign:          savingSessionsCampaign,
+			OctoPointsAwarded: joinedEvent.RewardGivenInOctoPoints,
+			Joined:            &hasJoined,
+		})
 	}
 
 	return events, nil
 }
 
 // fetchDavidKendallData fetches events from David Kendall's API with caching
-func fetchDavidKendallData() ([]Event, error) {
-	client := &http.Client{
-		Timeout: 15 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:       10,
-			IdleConnTimeout:    30 * time.Second,
-			DisableCompression: false,
-		},
-	}
+// in dir, or none when dir is empty, retrying transient failures
+func fetchDavidKendallData(ctx context.Context, client *http.Client, url string, policy retryPolicy, dir string) ([]Event, error) {
+	var events []Event
+	err := policy.do(ctx, "david_kendall", func() error {
+		var err error
+		events, err = fetchDavidKendallDataOnce(ctx, client, url, dir)
+		return err
+	})
+	return events, err
+}
 
-	req, err := http.NewRequest("GET", davidKendallAPI, nil)
+// fetchDavidKendallDataOnce makes a single request to David Kendall's API
+func fetchDavidKendallDataOnce(ctx context.Context, client *http.Client, url, dir string) ([]Event, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	// Add conditional request headers for caching
-	req.Header.Set("User-Agent", GetUserAgent())
 	req.Header.Set("Accept", "application/json")
 
 	// Check if we have cached ETag
-	if etag := getCachedETag(); etag != "" {
-		req.Header.Set("If-None-Match", etag)
+	if dir != "" {
+		if etag := getCachedETagFromDir(dir); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
 	}
 
 	resp, err := client.Do(req)
@@ -123,11 +473,14 @@ func fetchDavidKendallData() ([]Event, error) {
 	// Handle 304 Not Modified
 	if resp.StatusCode == http.StatusNotModified {
 		slog.Info("David Kendall's API data unchanged", "status", 304)
-		return getCachedEvents()
+		if dir == "" {
+			return nil, errors.New("david kendall's API reported no change, but there is no cache to answer from")
+		}
+		return getCachedEventsFromDir(dir)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, newHTTPStatusError(resp)
 	}
 
 	var outputData OutputData
@@ -136,8 +489,8 @@ func fetchDavidKendallData() ([]Event, error) {
 	}
 
 	// Cache the ETag for next request
-	if etag := resp.Header.Get("ETag"); etag != "" {
-		cacheETag(etag)
+	if etag := resp.Header.Get("ETag"); etag != "" && dir != "" {
+		cacheETagToDir(dir, etag)
 	}
 
 	// Convert to internal format
@@ -162,7 +515,9 @@ func fetchDavidKendallData() ([]Event, error) {
 	}
 
 	// Cache the events
-	cacheEvents(events)
+	if dir != "" {
+		cacheEventsToDir(dir, events)
+	}
 
 	slog.Info("Fetched events from David Kendall's API", "count", len(events))
 	return events, nil
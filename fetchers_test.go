@@ -16,8 +16,366 @@
 
 package main
 
-// Note: fetchOctopusEvents and fetchDavidKendallData are difficult to test
-// without mocking HTTP clients and GraphQL clients. These functions make
-// external API calls and would require significant refactoring to make
-// testable with mocks. For now, they remain at 0% coverage but are tested
-// indirectly through integration tests.
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// graphqlTestRequest is the JSON body sent by the GraphQL client
+type graphqlTestRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// graphqlTestResponse lets a test handler return errors alongside its data
+type graphqlTestResponse struct {
+	Data   interface{}          `json:"data"`
+	Errors []GraphQLErrorDetail `json:"errors,omitempty"`
+}
+
+// newKrakenTestServer starts a fake Kraken endpoint that issues tokens and
+// passes every other query to the supplied handler
+func newKrakenTestServer(t *testing.T, handler func(req graphqlTestRequest) interface{}) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req graphqlTestRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var data interface{}
+		if strings.Contains(req.Query, "obtainKrakenToken") {
+			data = map[string]interface{}{
+				"obtainKrakenToken": map[string]interface{}{
+					"token":            "test-token",
+					"refreshToken":     "test-refresh",
+					"refreshExpiresIn": time.Now().Add(time.Hour).Unix(),
+				},
+			}
+		} else {
+			data = handler(req)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if response, ok := data.(graphqlTestResponse); ok {
+			json.NewEncoder(w).Encode(response)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// testEventPage builds the aliased response for a single campaign page
+func testEventPage(codes []string, pageInfo PageInfo) interface{} {
+	return map[string]interface{}{
+		"enrolled0": true,
+		"events0":   testEventConnection(codes, pageInfo),
+	}
+}
+
+// testEventConnection builds a customerFlexibilityCampaignEvents connection
+func testEventConnection(codes []string, pageInfo PageInfo) interface{} {
+	edges := make([]interface{}, 0, len(codes))
+	for i, code := range codes {
+		start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC).Add(time.Duration(i) * 24 * time.Hour)
+		edges = append(edges, map[string]interface{}{
+			"cursor": code,
+			"node": map[string]interface{}{
+				"code":    code,
+				"startAt": start.Format(time.RFC3339),
+				"endAt":   start.Add(time.Hour).Format(time.RFC3339),
+				"name":    "Free Electricity " + code,
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"edges":    edges,
+		"pageInfo": pageInfo,
+	}
+}
+
+func testFetchConfig() *Config {
+	return &Config{
+		AccountNumber: "A-12345678",
+		MeterPointID:  "1000000000000",
+		APIKey:        "sk_live_test_key",
+	}
+}
+
+func TestFetchOctopusEvents_FollowsPagination(t *testing.T) {
+	var cursors []interface{}
+	server := newKrakenTestServer(t, func(req graphqlTestRequest) interface{} {
+		cursors = append(cursors, req.Variables["cursor0"])
+		switch req.Variables["cursor0"] {
+		case nil:
+			return testEventPage([]string{"a1", "a2"}, PageInfo{EndCursor: "c1", HasNextPage: true})
+		case "c1":
+			return testEventPage([]string{"b1", "b2"}, PageInfo{EndCursor: "c2", HasNextPage: true})
+		default:
+			return testEventPage([]string{"c1"}, PageInfo{EndCursor: "c3", HasNextPage: false})
+		}
+	})
+
+	client := NewAuthenticatedClient("sk_live_test_key", server.URL)
+	events, _, _, err := fetchOctopusEventsWithClient(context.Background(), client, testFetchConfig())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(events) != 5 {
+		t.Errorf("Expected 5 events across 3 pages, got %d", len(events))
+	}
+	if len(cursors) != 3 || cursors[1] != "c1" || cursors[2] != "c2" {
+		t.Errorf("Unexpected cursor sequence %v", cursors)
+	}
+}
+
+func TestFetchOctopusEvents_StopsOnRepeatedCursor(t *testing.T) {
+	requests := 0
+	server := newKrakenTestServer(t, func(req graphqlTestRequest) interface{} {
+		requests++
+		return testEventPage([]string{fmt.Sprintf("e%d", requests)}, PageInfo{EndCursor: "same", HasNextPage: true})
+	})
+
+	client := NewAuthenticatedClient("sk_live_test_key", server.URL)
+	events, _, _, err := fetchOctopusEventsWithClient(context.Background(), client, testFetchConfig())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if requests != 2 {
+		t.Errorf("Expected pagination to stop after cursor repeated, made %d requests", requests)
+	}
+	if len(events) != 2 {
+		t.Errorf("Expected 2 events, got %d", len(events))
+	}
+}
+
+func TestFetchOctopusEvents_PageLimit(t *testing.T) {
+	requests := 0
+	server := newKrakenTestServer(t, func(req graphqlTestRequest) interface{} {
+		requests++
+		return testEventPage([]string{fmt.Sprintf("e%d", requests)}, PageInfo{EndCursor: fmt.Sprintf("c%d", requests), HasNextPage: true})
+	})
+
+	client := NewAuthenticatedClient("sk_live_test_key", server.URL)
+	if _, _, _, err := fetchOctopusEventsWithClient(context.Background(), client, testFetchConfig()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if requests != maxOctopusPages {
+		t.Errorf("Expected %d requests, got %d", maxOctopusPages, requests)
+	}
+}
+
+func TestFetchOctopusHistory_WalksBackwards(t *testing.T) {
+	var befores []interface{}
+	server := newKrakenTestServer(t, func(req graphqlTestRequest) interface{} {
+		if !strings.Contains(req.Query, "last: $pageSize") || !strings.Contains(req.Query, "before: $cursor0") {
+			t.Errorf("Expected backwards pagination arguments in query %s", req.Query)
+		}
+
+		befores = append(befores, req.Variables["cursor0"])
+		switch req.Variables["cursor0"] {
+		case nil:
+			return testEventPage([]string{"n1", "n2"}, PageInfo{StartCursor: "s2", HasPreviousPage: true})
+		case "s2":
+			return testEventPage([]string{"m1", "m2"}, PageInfo{StartCursor: "s1", HasPreviousPage: true})
+		default:
+			return testEventPage([]string{"o1"}, PageInfo{StartCursor: "s0", HasPreviousPage: false})
+		}
+	})
+
+	client := NewAuthenticatedClient("sk_live_test_key", server.URL)
+	events, _, err := fetchOctopusHistoryWithClient(context.Background(), client, testFetchConfig())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(events) != 5 {
+		t.Errorf("Expected 5 historical events, got %d", len(events))
+	}
+	if len(befores) != 3 || befores[1] != "s2" || befores[2] != "s1" {
+		t.Errorf("Unexpected cursor sequence %v", befores)
+	}
+}
+
+func TestFetchOctopusEvents_PartialFailure(t *testing.T) {
+	server := newKrakenTestServer(t, func(req graphqlTestRequest) interface{} {
+		return graphqlTestResponse{
+			Data: map[string]interface{}{
+				"enrolled0": true,
+				"events0":   testEventConnection([]string{"fe1", "fe2"}, PageInfo{}),
+				"enrolled1": nil,
+				"events1":   nil,
+			},
+			Errors: []GraphQLErrorDetail{
+				{Message: "Campaign not found", Path: []interface{}{"events1"}},
+				{Message: "Campaign not found", Path: []interface{}{"enrolled1"}},
+			},
+		}
+	})
+
+	config := testFetchConfig()
+	config.Campaigns = []CampaignConfig{{Slug: "free_electricity"}, {Slug: "other_campaign"}}
+
+	client := NewAuthenticatedClient("sk_live_test_key", server.URL)
+	events, enrollment, campaignErrs, err := fetchOctopusEventsWithClient(context.Background(), client, config)
+	if err != nil {
+		t.Fatalf("Expected the other campaign's events to be kept, got %v", err)
+	}
+
+	if len(events) != 2 || events[0].Campaign != "free_electricity" {
+		t.Errorf("Expected the free electricity events, got %+v", events)
+	}
+	if _, ok := enrollment["other_campaign"]; ok || !enrollment["free_electricity"] {
+		t.Errorf("Expected enrollment only for the campaign that succeeded, got %v", enrollment)
+	}
+	if len(campaignErrs) != 1 || errorClassOf(campaignErrs["other_campaign"]) != ErrorClassNotFound {
+		t.Errorf("Expected a not found error for the failed campaign, got %v", campaignErrs)
+	}
+}
+
+func TestFetchOctopusEvents_EveryCampaignFails(t *testing.T) {
+	server := newKrakenTestServer(t, func(req graphqlTestRequest) interface{} {
+		return graphqlTestResponse{
+			Data:   map[string]interface{}{"enrolled0": true, "events0": nil},
+			Errors: []GraphQLErrorDetail{{Message: "Campaign not found", Path: []interface{}{"events0"}}},
+		}
+	})
+
+	client := NewAuthenticatedClient("sk_live_test_key", server.URL)
+	if _, _, _, err := fetchOctopusEventsWithClient(context.Background(), client, testFetchConfig()); err == nil {
+		t.Error("Expected an error when every campaign fails")
+	}
+}
+
+func TestFetchOctopusEvents_MultipleCampaigns(t *testing.T) {
+	requests := 0
+	server := newKrakenTestServer(t, func(req graphqlTestRequest) interface{} {
+		requests++
+		if req.Variables["slug0"] != "free_electricity" || req.Variables["slug1"] != "other_campaign" {
+			t.Errorf("Unexpected campaign slugs %v", req.Variables)
+		}
+
+		return map[string]interface{}{
+			"enrolled0": true,
+			"events0":   testEventConnection([]string{"fe1", "fe2"}, PageInfo{}),
+			"enrolled1": false,
+			"events1":   testEventConnection([]string{"oc1"}, PageInfo{}),
+		}
+	})
+
+	config := testFetchConfig()
+	config.Campaigns = []CampaignConfig{
+		{Slug: "free_electricity"},
+		{Slug: "other_campaign"},
+		{Slug: "disabled_campaign", Enabled: boolPtr(false)},
+	}
+
+	client := NewAuthenticatedClient("sk_live_test_key", server.URL)
+	events, enrollment, _, err := fetchOctopusEventsWithClient(context.Background(), client, config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !enrollment["free_electricity"] || enrollment["other_campaign"] {
+		t.Errorf("Unexpected enrollment %v", enrollment)
+	}
+	if requests != 1 {
+		t.Errorf("Expected campaigns to share a single request, made %d", requests)
+	}
+	if got := len(campaignEvents(events, "free_electricity")); got != 2 {
+		t.Errorf("Expected 2 free electricity events, got %d", got)
+	}
+	if got := len(campaignEvents(events, "other_campaign")); got != 1 {
+		t.Errorf("Expected 1 other campaign event, got %d", got)
+	}
+}
+
+func TestBuildCampaignEventsQuery(t *testing.T) {
+	query := buildCampaignEventsQuery([]int{0, 2}, false)
+
+	for _, expected := range []string{"$slug0: String!", "$cursor2: String", "events2: customerFlexibilityCampaignEvents", "first: $pageSize", "after: $cursor0"} {
+		if !strings.Contains(query, expected) {
+			t.Errorf("Expected query to contain %q", expected)
+		}
+	}
+	if strings.Contains(query, "events1:") {
+		t.Error("Query should only include the requested campaign indexes")
+	}
+}
+
+func TestFetchSavingSessions(t *testing.T) {
+	server := newKrakenTestServer(t, func(req graphqlTestRequest) interface{} {
+		if req.Variables["accountNumber"] != "A-12345678" {
+			t.Errorf("Unexpected account number %v", req.Variables["accountNumber"])
+		}
+
+		return map[string]interface{}{
+			"savingSessions": map[string]interface{}{
+				"events": []interface{}{
+					map[string]interface{}{"id": 10, "code": "SS-10", "startAt": "2025-01-10T17:00:00Z", "endAt": "2025-01-10T18:00:00Z", "rewardPerKwhInOctoPoints": 800},
+					map[string]interface{}{"id": 11, "code": "SS-11", "startAt": "2025-01-11T17:30:00Z", "endAt": "2025-01-11T18:30:00Z", "rewardPerKwhInOctoPoints": 1200},
+				},
+				"account": map[string]interface{}{
+					"hasJoinedCampaign": true,
+					"joinedEvents": []interface{}{
+						map[string]interface{}{"eventId": 10, "startAt": "2025-01-10T17:00:00Z", "endAt": "2025-01-10T18:00:00Z", "rewardGivenInOctoPoints": 1600},
+						map[string]interface{}{"eventId": 3, "startAt": "2024-12-01T17:00:00Z", "endAt": "2024-12-01T18:00:00Z", "rewardGivenInOctoPoints": 400},
+					},
+				},
+			},
+		}
+	})
+
+	client := NewAuthenticatedClient("sk_live_test_key", server.URL)
+	events, err := fetchSavingSessionsWithClient(context.Background(), client, testFetchConfig())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 saving sessions, got %d", len(events))
+	}
+
+	byCode := make(map[string]Event)
+	for _, event := range events {
+		if event.Campaign != savingSessionsCampaign {
+			t.Errorf("Expected event %s to be tagged %q, got %q", event.Code, savingSessionsCampaign, event.Campaign)
+		}
+		byCode[event.Code] = event
+	}
+
+	joined := byCode["SS-10"]
+	if joined.Joined == nil || !*joined.Joined || joined.OctoPointsAwarded == nil || *joined.OctoPointsAwarded != 1600 {
+		t.Errorf("Expected SS-10 to be joined with 1600 points awarded, got %+v", joined)
+	}
+	if joined.OctoPointsPerKwh == nil || *joined.OctoPointsPerKwh != 800 {
+		t.Errorf("Expected SS-10 to pay 800 points per kWh, got %+v", joined.OctoPointsPerKwh)
+	}
+
+	notJoined := byCode["SS-11"]
+	if notJoined.Joined == nil || *notJoined.Joined || notJoined.OctoPointsAwarded != nil {
+		t.Errorf("Expected SS-11 to be not joined, got %+v", notJoined)
+	}
+
+	if past, ok := byCode["3"]; !ok || past.OctoPointsAwarded == nil || *past.OctoPointsAwarded != 400 {
+		t.Errorf("Expected past joined session to be kept with its reward, got %+v", past)
+	}
+}
+
+// Note: fetchDavidKendallData talks to a fixed external URL and remains
+// covered only indirectly through integration tests.
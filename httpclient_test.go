@@ -0,0 +1,111 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewHTTPClient_UserAgentSuffix(t *testing.T) {
+	var userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+	}))
+	defer server.Close()
+
+	client, err := newHTTPClient(HTTPConfig{UserAgentSuffix: "corp-runner/1"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if !strings.HasPrefix(userAgent, GetUserAgent()) || !strings.HasSuffix(userAgent, " corp-runner/1") {
+		t.Errorf("Unexpected User-Agent %q", userAgent)
+	}
+}
+
+func TestNewHTTPClient_CAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, certPEM, 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	untrusted, _ := newHTTPClient(HTTPConfig{})
+	if _, err := untrusted.Get(server.URL); err == nil {
+		t.Fatal("Expected request to fail without the private CA")
+	}
+
+	client, err := newHTTPClient(HTTPConfig{CAFile: caFile, TLSMinVersion: "1.2"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected request to succeed with the private CA, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewHTTPClient_Proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	client, err := newHTTPClient(HTTPConfig{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp, err := client.Get("http://upstream.invalid/feed.json")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if proxied != "http://upstream.invalid/feed.json" {
+		t.Errorf("Expected request to go through the proxy, got %q", proxied)
+	}
+}
+
+func TestNewHTTPClient_InvalidSettings(t *testing.T) {
+	tests := map[string]HTTPConfig{
+		"proxy":       {Proxy: "::not a url"},
+		"ca file":     {CAFile: filepath.Join(t.TempDir(), "missing.pem")},
+		"tls version": {TLSMinVersion: "1.0"},
+	}
+
+	for name, settings := range tests {
+		if _, err := newHTTPClient(settings); err == nil {
+			t.Errorf("Expected error for invalid %s, got nil", name)
+		}
+	}
+}
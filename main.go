@@ -17,15 +17,40 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
+// commands are run instead of the event update when named as the first
+// argument, each parsing its own flags
+var commands = map[string]func(args []string) error{
+	"mock-server":  runMockServer,
+	"schema-check": runSchemaCheck,
+	"freeze-codes": runFreezeCodes,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			setupLogging()
+			if err := command(os.Args[2:]); err != nil {
+				slog.Error("Command failed", "command", os.Args[1], "error", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// Parse flags first to get log format preference
 	flag.Parse()
 
@@ -40,7 +65,26 @@ func main() {
 
 	slog.Info("Starting octoevents", "version", GetVersion())
 
-	if err := fetchAndUpdateEvents(config); err != nil {
+	ctx, cancel := newRunContext(config)
+	defer cancel()
+
+	if *backfill {
+		if err := backfillEvents(ctx, config); err != nil {
+			slog.Error("Failed to backfill events", "error", err)
+			cancel()
+			os.Exit(1)
+		}
+
+		slog.Info("Successfully completed event backfill")
+		return
+	}
+
+	if err := fetchAndUpdateEvents(ctx, config); err != nil {
+		cancel()
+		if errors.Is(err, errNotEnrolled) {
+			slog.Warn("Completed event update, but meter point is not enrolled", "error", err)
+			os.Exit(exitNotEnrolled)
+		}
 		slog.Error("Failed to fetch and update events", "error", err)
 		os.Exit(1)
 	}
@@ -48,101 +92,303 @@ func main() {
 	slog.Info("Successfully completed event update")
 }
 
-func fetchAndUpdateEvents(config *Config) error {
-	// Always load existing events first - this is our safety net
-	existingEvents, err := loadExistingEvents(config.OutputFile)
-	if err != nil && !os.IsNotExist(err) {
-		return errors.Wrap(err, "failed to load existing events")
+// newRunContext returns the context for a whole run, cancelled by SIGINT or
+// SIGTERM or once the run deadline passes
+func newRunContext(config *Config) (context.Context, context.CancelFunc) {
+	timeout := config.RunTimeout
+	if timeout <= 0 {
+		timeout = defaultRunTimeout
 	}
 
-	slog.Info("Loaded existing events", "count", len(existingEvents))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 
-	// Fetch events from both APIs concurrently
-	type fetchResult struct {
-		events []Event
-		source string
-		err    error
+	return ctx, func() {
+		cancel()
+		stop()
 	}
+}
 
-	results := make(chan fetchResult, 2)
+func fetchAndUpdateEvents(ctx context.Context, config *Config) error {
+	cleanup, err := isolateRecordedRun(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to isolate recorded run")
+	}
+	defer cleanup()
 
-	// Fetch Octopus events
-	go func() {
-		events, err := fetchOctopusEvents(config)
-		results <- fetchResult{events: events, source: "octopus", err: err}
-	}()
+	httpClient, err := newRunHTTPClient(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to configure HTTP client")
+	}
 
-	// Fetch David Kendall's data
-	go func() {
-		events, err := fetchDavidKendallData()
-		results <- fetchResult{events: events, source: "david_kendall", err: err}
-	}()
+	clients := newSourceClients(config, httpClient)
+	sources, err := buildSources(config, clients)
+	if err != nil {
+		return errors.Wrap(err, "failed to configure event sources")
+	}
 
-	// Collect results
-	var octopusEvents, externalEvents []Event
-	for i := 0; i < 2; i++ {
-		result := <-results
-		if result.err != nil {
-			slog.Warn("Failed to fetch events", "source", result.source, "error", result.err)
-			if result.source == "octopus" {
-				octopusEvents = []Event{}
-			} else {
-				externalEvents = []Event{}
+	// Skip sources that have kept failing until their cool-off has passed
+	breaker, err := loadCircuitBreaker(config)
+	if err != nil {
+		slog.Warn("Failed to load source health, resetting circuit breakers", "error", err)
+	}
+	sources = breaker.filter(sources, time.Now())
+
+	// Fetch all enabled sources concurrently, results come back in merge order
+	results := fetchSources(ctx, sources, config.MaxConcurrentFetches)
+
+	breaker.record(ctx, results, time.Now())
+	if err := breaker.save(); err != nil {
+		slog.Warn("Failed to save source health", "error", err)
+	}
+
+	fetchErr := checkSourceErrors(results)
+	if config.ReplayDir != "" && allSourcesFailed(results) {
+		return fmt.Errorf("no source could be replayed from %s", config.ReplayDir)
+	}
+
+	// Joining events is best effort and must never block the feed update
+	if config.OptIn.Enabled {
+		if err := runOptIn(ctx, config, clients.kraken, results); err != nil {
+			slog.Warn("Failed to opt in to events", "error", err)
+		}
+	}
+
+	enrollmentErr := checkEnrollment(config, results)
+
+	if err := updateCampaigns(ctx, config, results); err != nil {
+		return err
+	}
+
+	updatePrivateOutputIfEnabled(ctx, config, results)
+
+	if fetchErr != nil {
+		return fetchErr
+	}
+	return enrollmentErr
+}
+
+// checkSourceErrors logs the outcome of each source. Sources that cannot be
+// fetched because of credentials or permissions fail the run, since retrying
+// will not help; other failures only warn so the remaining sources are used
+func checkSourceErrors(results []sourceResult) error {
+	var fetchErr error
+	for _, result := range results {
+		if result.err == nil {
+			slog.Info("Fetched events", "source", result.name, "count", len(result.events))
+			// A campaign the account may not read fails the run like a source would
+			for _, campaign := range slices.Sorted(maps.Keys(result.campaignErrs)) {
+				err := result.campaignErrs[campaign]
+				if class := errorClassOf(err); fetchErr == nil && (class == ErrorClassAuth || class == ErrorClassPermission) {
+					slog.Error("Failed to fetch campaign events", "source", result.name, "campaign", campaign, "class", class, "error", err)
+					fetchErr = errors.Wrapf(err, "source %s failed for campaign %s", result.name, campaign)
+				}
 			}
-		} else {
-			slog.Info("Fetched events", "source", result.source, "count", len(result.events))
-			if result.source == "octopus" {
-				octopusEvents = result.events
-			} else {
-				externalEvents = result.events
+			continue
+		}
+
+		class := errorClassOf(result.err)
+		switch class {
+		case ErrorClassAuth, ErrorClassPermission:
+			slog.Error("Failed to fetch events", "source", result.name, "class", class, "error", result.err)
+			if fetchErr == nil {
+				fetchErr = errors.Wrapf(result.err, "source %s failed", result.name)
 			}
+		default:
+			slog.Warn("Failed to fetch events", "source", result.name, "class", class, "error", result.err)
 		}
 	}
+	return fetchErr
+}
+
+// allSourcesFailed reports whether every fetched source returned an error
+func allSourcesFailed(results []sourceResult) bool {
+	for _, result := range results {
+		if result.err == nil {
+			return false
+		}
+	}
+	return len(results) > 0
+}
+
+// backfillEvents walks the complete Octopus campaign history backwards and
+// merges it into each campaign's output file, without consulting any other source
+func backfillEvents(ctx context.Context, config *Config) error {
+	cleanup, err := isolateRecordedRun(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to isolate recorded run")
+	}
+	defer cleanup()
+
+	httpClient, err := newRunHTTPClient(config)
+	if err != nil {
+		return errors.Wrap(err, "failed to configure HTTP client")
+	}
+
+	history, campaignErrs, err := fetchOctopusHistory(ctx, config, httpClient)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch Octopus event history")
+	}
+
+	slog.Info("Fetched Octopus event history", "count", len(history))
+
+	results := []sourceResult{{name: "octopus", events: history, campaignErrs: campaignErrs}}
+	if err := updateCampaigns(ctx, config, results); err != nil {
+		return err
+	}
+
+	updatePrivateOutputIfEnabled(ctx, config, results)
+
+	return nil
+}
+
+// updatePrivateOutputIfEnabled records participation in the private output
+// when one is configured. It is an audit trail and never fails the run
+func updatePrivateOutputIfEnabled(ctx context.Context, config *Config, results []sourceResult) {
+	if config.PrivateOutputFile == "" {
+		return
+	}
+	if err := updatePrivateOutput(ctx, config, results); err != nil {
+		slog.Warn("Failed to update private output", "error", err)
+	}
+}
+
+// updateCampaigns updates the output file of every enabled campaign, carrying
+// on past failures so one broken campaign does not block the others
+func updateCampaigns(ctx context.Context, config *Config, results []sourceResult) error {
+	var firstErr error
+	for _, campaign := range outputTargets(config) {
+		if err := updateCampaign(ctx, config, campaign, results); err != nil {
+			slog.Error("Failed to update campaign", "campaign", campaign.Slug, "error", err)
+			if firstErr == nil {
+				firstErr = errors.Wrapf(err, "failed to update campaign %s", campaign.Slug)
+			}
+		}
+	}
+	return firstErr
+}
+
+// updateCampaign merges the fetched events for one campaign into its output file
+func updateCampaign(ctx context.Context, config *Config, campaign CampaignConfig, results []sourceResult) error {
+	// Always load existing events first - this is our safety net
+	existingEvents, err := loadExistingEvents(campaign.OutputFile)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to load existing events")
+	}
+
+	slog.Info("Loaded existing events", "campaign", campaign.Slug, "count", len(existingEvents))
+
+	// The feed must not depend on private state, so unreadable state only
+	// disables cancellation tracking and private codes for this run
+	statePath := stateFilePath(config)
+	state, err := loadState(statePath)
+	if err != nil {
+		slog.Warn("Failed to load state, cancellations and private codes not tracked",
+			"campaign", campaign.Slug, "error", err)
+		state = nil
+	} else {
+		existingEvents = withPrivateCodes(existingEvents, campaign.Slug, state.Codes)
+	}
 
 	// Start with existing events as the base (never lose data)
+	now := runClock(config)
 	allEvents := make([]Event, len(existingEvents))
 	copy(allEvents, existingEvents)
+	allEvents = withExistingProvenance(allEvents, now)
+
+	policy, err := newMergePolicy(config.MergePolicy)
+	if err != nil {
+		return err
+	}
+
+	// Merge each source in ascending priority so higher priority sources win
+	// conflicts, unless the merge policy says otherwise
+	fetchedCount := 0
+	for _, result := range results {
+		if result.err != nil {
+			continue
+		}
 
-	// Merge in external events if we got any
-	if len(externalEvents) > 0 {
-		allEvents = mergeEvents(allEvents, externalEvents)
+		events := withSource(campaignEvents(result.events, campaign.Slug), result.name, now)
+		fetchedCount += len(events)
+
+		if len(events) > 0 {
+			allEvents, events = reconcileRescheduled(allEvents, events, rescheduleTolerance(config))
+			allEvents = mergeEventsWithPolicy(allEvents, events, policy)
+		}
 	}
 
-	// Merge in new Octopus events if we got any
-	if len(octopusEvents) > 0 {
-		allEvents = mergeEvents(allEvents, octopusEvents)
+	if state != nil {
+		missing, codes := maps.Clone(state.Missing), maps.Clone(state.Codes)
+		allEvents = trackCampaignCancellations(config, campaign, state, existingEvents, allEvents, results, now)
+		state.Codes = rememberPrivateCodes(state.Codes, campaign.Slug, allEvents)
+		if !maps.Equal(missing, state.Missing) || !maps.Equal(codes, state.Codes) {
+			if err := saveState(statePath, state); err != nil {
+				slog.Warn("Failed to save state", "campaign", campaign.Slug, "error", err)
+			}
+		}
 	}
 
+	finalEvents, err := writeMergedEvents(ctx, campaign.OutputFile, existingEvents, allEvents)
+	if err != nil {
+		return err
+	}
+	if finalEvents == nil {
+		return nil
+	}
+
+	slog.Info("Successfully updated events",
+		"campaign", campaign.Slug,
+		"file", campaign.OutputFile,
+		"total_count", len(finalEvents),
+		"existing_count", len(existingEvents),
+		"sources", len(results),
+		"fetched_events", fetchedCount,
+		"new_events_added", len(finalEvents)-len(existingEvents))
+
+	return nil
+}
+
+// trackCampaignCancellations marks events missing from their authoritative
+// source as cancelled, counting the missed runs in the state
+func trackCampaignCancellations(config *Config, campaign CampaignConfig, state *State, existingEvents, allEvents []Event, results []sourceResult, now time.Time) []Event {
+	if state.Missing == nil {
+		state.Missing = make(map[string]int)
+	}
+	return trackCancellations(campaign.Slug, existingEvents, allEvents, results,
+		state.Missing, cancellationThreshold(config), now)
+}
+
+// writeMergedEvents assigns codes and saves the merged events, returning nil
+// without writing when nothing changed
+func writeMergedEvents(ctx context.Context, filename string, existingEvents, allEvents []Event) ([]Event, error) {
 	// Check if we actually have any changes
 	if !hasChanges(existingEvents, allEvents) {
-		slog.Info("No new events detected, skipping file update")
-		return nil
+		slog.Info("No event changes detected, skipping file update")
+		return nil, nil
 	}
 
-	// Assign sequential codes to the final merged set
-	finalEvents := assignSequentialCodes(allEvents)
+	// Keep published codes and number only the new events
+	finalEvents := assignStableCodes(existingEvents, allEvents)
 
 	// Final safety check: never write fewer events than we started with
 	if len(finalEvents) < len(existingEvents) {
 		slog.Warn("Refusing to write fewer events than existing",
 			"existing", len(existingEvents),
 			"new", len(finalEvents))
-		return fmt.Errorf("safety check failed: would reduce event count from %d to %d",
+		return nil, fmt.Errorf("safety check failed: would reduce event count from %d to %d",
 			len(existingEvents), len(finalEvents))
 	}
 
-	// Save the updated events
-	if err := saveEvents(finalEvents, config.OutputFile); err != nil {
-		return errors.Wrap(err, "failed to save events")
+	// Never start a write once the run has been cancelled or timed out
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(err, "run cancelled before saving events")
 	}
 
-	slog.Info("Successfully updated events",
-		"file", config.OutputFile,
-		"total_count", len(finalEvents),
-		"existing_count", len(existingEvents),
-		"octopus_events", len(octopusEvents),
-		"external_events", len(externalEvents),
-		"new_events_added", len(finalEvents)-len(existingEvents))
+	// Save the updated events
+	if err := saveEvents(finalEvents, filename); err != nil {
+		return nil, errors.Wrap(err, "failed to save events")
+	}
 
-	return nil
+	return finalEvents, nil
 }
@@ -17,9 +17,12 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestSetupLogging(t *testing.T) {
@@ -54,11 +57,15 @@ func TestFetchAndUpdateEvents_NoExistingFile(t *testing.T) {
 		MeterPointID:  "1000000000000",
 		APIKey:        "sk_live_test_key",
 		OutputFile:    outputFile,
+		StateFile:     filepath.Join(tempDir, "state.json"),
+		CircuitBreaker: CircuitBreakerConfig{
+			HealthFile: filepath.Join(tempDir, "health.json"),
+		},
 	}
 
 	// This will fail because we don't have real API credentials,
 	// but it should still exercise the code path and create the output file
-	err := fetchAndUpdateEvents(config)
+	err := fetchAndUpdateEvents(context.Background(), config)
 
 	// We expect this to fail due to invalid API credentials, but it should not panic
 	if err == nil {
@@ -98,10 +105,14 @@ func TestFetchAndUpdateEvents_WithExistingEvents(t *testing.T) {
 		MeterPointID:  "1000000000000",
 		APIKey:        "sk_live_test_key",
 		OutputFile:    outputFile,
+		StateFile:     filepath.Join(tempDir, "state.json"),
+		CircuitBreaker: CircuitBreakerConfig{
+			HealthFile: filepath.Join(tempDir, "health.json"),
+		},
 	}
 
 	// This should load existing events and attempt to fetch new ones
-	err = fetchAndUpdateEvents(config)
+	err = fetchAndUpdateEvents(context.Background(), config)
 
 	// We expect this to fail due to invalid API credentials, but it should handle existing events
 	if err == nil {
@@ -113,3 +124,46 @@ func TestFetchAndUpdateEvents_WithExistingEvents(t *testing.T) {
 		t.Errorf("Output file was deleted: %s", outputFile)
 	}
 }
+
+func TestCheckSourceErrors(t *testing.T) {
+	notFound := &GraphQLError{Class: ErrorClassNotFound}
+	if err := checkSourceErrors([]sourceResult{{name: "octopus", err: notFound}}); err != nil {
+		t.Errorf("Expected not found errors only to warn, got %v", err)
+	}
+
+	auth := &GraphQLError{Class: ErrorClassAuth}
+	err := checkSourceErrors([]sourceResult{{name: "david_kendall"}, {name: "octopus", err: auth}})
+	if !errors.Is(err, auth) {
+		t.Errorf("Expected auth error to fail the run, got %v", err)
+	}
+}
+
+func TestNewRunContext_Deadline(t *testing.T) {
+	ctx, cancel := newRunContext(&Config{RunTimeout: 10 * time.Millisecond})
+	defer cancel()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected run context to expire at its deadline")
+	}
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", ctx.Err())
+	}
+}
+
+func TestWriteMergedEvents_CancelledRun(t *testing.T) {
+	outputFile := filepath.Join(t.TempDir(), "test_output.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	events := []Event{{StartAt: start, EndAt: start.Add(time.Hour)}}
+
+	if _, err := writeMergedEvents(ctx, outputFile, nil, events); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancelled run not to write, got %v", err)
+	}
+	if _, err := os.Stat(outputFile); !os.IsNotExist(err) {
+		t.Error("Expected no output file to be written after cancellation")
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Field merge strategies, applied when two sources report the same window
+const (
+	// mergePriority keeps the value of the higher priority source
+	mergePriority = "priority"
+	// mergeEarliest keeps the value of the report whose source was seen first
+	mergeEarliest = "earliest"
+	// mergeOr sets a boolean if any source sets it
+	mergeOr = "or"
+	// mergePrefer keeps the value of the named source, written prefer:<source>
+	mergePrefer = "prefer"
+)
+
+// mergeFields lists the event fields a policy can be set for, with their
+// default strategy. Only boolean fields can use mergeOr
+var mergeFields = map[string]struct {
+	strategy string
+	boolean  bool
+}{
+	"name":               {mergePriority, false},
+	"isTest":             {mergeOr, true},
+	"isEventParticipant": {mergeOr, true},
+	"joined":             {mergeOr, true},
+	"region":             {mergePriority, false},
+	"octoPointsPerKwh":   {mergePriority, false},
+	"octoPointsAwarded":  {mergePriority, false},
+}
+
+// fieldPolicy is the strategy for one field
+type fieldPolicy struct {
+	strategy string
+	source   string
+}
+
+// mergePolicy holds the strategy for every mergeable field
+type mergePolicy map[string]fieldPolicy
+
+// defaultMergePolicy returns the policy used when none is configured
+func defaultMergePolicy() mergePolicy {
+	policy := make(mergePolicy, len(mergeFields))
+	for field, def := range mergeFields {
+		policy[field] = fieldPolicy{strategy: def.strategy}
+	}
+	return policy
+}
+
+// newMergePolicy applies configured strategies over the defaults
+func newMergePolicy(config map[string]string) (mergePolicy, error) {
+	policy := defaultMergePolicy()
+	for field, value := range config {
+		def, ok := mergeFields[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown merge policy field %q (use one of %s)", field, strings.Join(mergeFieldNames(), ", "))
+		}
+
+		strategy, source, _ := strings.Cut(value, ":")
+		switch strategy {
+		case mergePriority, mergeEarliest:
+		case mergeOr:
+			if !def.boolean {
+				return nil, fmt.Errorf("merge policy %q can only be used for boolean fields, not %s", mergeOr, field)
+			}
+		case mergePrefer:
+			if _, ok := sourceRegistry[source]; !ok {
+				return nil, fmt.Errorf("merge policy for %s prefers unknown source %q", field, source)
+			}
+		default:
+			return nil, fmt.Errorf("unknown merge policy %q for %s (use priority, earliest, or or prefer:<source>)", value, field)
+		}
+		policy[field] = fieldPolicy{strategy: strategy, source: source}
+	}
+	return policy, nil
+}
+
+// mergeFieldNames returns the configurable fields in order
+func mergeFieldNames() []string {
+	names := make([]string, 0, len(mergeFields))
+	for field := range mergeFields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// merge combines two reports of the same window field by field, starting
+// from the current, higher priority report. A value only one report has is
+// always kept
+func (p mergePolicy) merge(previous, current Event) Event {
+	merged := current
+	merged.Name = mergeString(p, "name", previous, current, previous.Name, current.Name)
+	merged.IsTest = mergePtr(p, "isTest", previous, current, previous.IsTest, current.IsTest)
+	merged.IsEventParticipant = mergeValue(p, "isEventParticipant", previous, current, previous.IsEventParticipant, current.IsEventParticipant)
+	merged.Joined = mergePtr(p, "joined", previous, current, previous.Joined, current.Joined)
+	merged.Region = mergeString(p, "region", previous, current, previous.Region, current.Region)
+	merged.OctoPointsPerKwh = mergePtr(p, "octoPointsPerKwh", previous, current, previous.OctoPointsPerKwh, current.OctoPointsPerKwh)
+	merged.OctoPointsAwarded = mergePtr(p, "octoPointsAwarded", previous, current, previous.OctoPointsAwarded, current.OctoPointsAwarded)
+	return merged
+}
+
+// mergeString merges a field where the empty string means unset
+func mergeString(p mergePolicy, field string, previous, current Event, prev, curr string) string {
+	if prev == "" {
+		return curr
+	}
+	if curr == "" {
+		return prev
+	}
+	return mergeValue(p, field, previous, current, prev, curr)
+}
+
+// mergePtr merges a field where nil means unset
+func mergePtr[T comparable](p mergePolicy, field string, previous, current Event, prev, curr *T) *T {
+	if prev == nil {
+		return curr
+	}
+	if curr == nil {
+		return prev
+	}
+	if mergeValue(p, field, previous, current, *prev, *curr) == *prev {
+		return prev
+	}
+	return curr
+}
+
+// mergeValue picks between two set values using the field's policy, logging
+// when different sources disagree
+func mergeValue[T comparable](p mergePolicy, field string, previous, current Event, prev, curr T) T {
+	if prev == curr {
+		return curr
+	}
+
+	policy := p[field]
+	keepPrevious := false
+	switch policy.strategy {
+	case mergeEarliest:
+		// Unknown sightings keep the value already merged
+		seen := firstSeen(previous, current, current.Source)
+		keepPrevious = seen.IsZero() || !seen.Before(earliestSeen(previous))
+	case mergeOr:
+		keepPrevious, _ = any(prev).(bool)
+	case mergePrefer:
+		keepPrevious = current.Source != policy.source && previous.reportedBy(policy.source)
+	}
+
+	result := curr
+	kept := current.Source
+	if keepPrevious {
+		result, kept = prev, previous.Source
+	}
+
+	if previous.Source != "" && current.Source != "" && previous.Source != current.Source {
+		slog.Info("Sources disagree on event field",
+			"field", field, "start", current.StartAt, "end", current.EndAt,
+			"previous_source", previous.Source, "previous_value", prev,
+			"source", current.Source, "value", curr,
+			"policy", policy.strategy, "kept", kept)
+	}
+
+	return result
+}
+
+// earliestSeen returns when the earliest source of an event was first seen,
+// or the zero time if its provenance is unknown
+func earliestSeen(event Event) time.Time {
+	var earliest time.Time
+	for _, p := range event.Provenance {
+		if earliest.IsZero() || p.FirstSeen.Before(earliest) {
+			earliest = p.FirstSeen
+		}
+	}
+	return earliest
+}
+
+// firstSeen returns when a source was first seen reporting an event across
+// two reports of it
+func firstSeen(previous, current Event, source string) time.Time {
+	var seen time.Time
+	for _, provenance := range [][]Provenance{previous.Provenance, current.Provenance} {
+		for _, p := range provenance {
+			if p.Source == source && (seen.IsZero() || p.FirstSeen.Before(seen)) {
+				seen = p.FirstSeen
+			}
+		}
+	}
+	return seen
+}
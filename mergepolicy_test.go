@@ -0,0 +1,144 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMergeEvents_KeepsFieldOnlyOneSourceHas(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	feed := Event{Source: "david_kendall", StartAt: start, EndAt: start.Add(time.Hour), IsTest: boolPtr(true)}
+	octopus := Event{Source: "octopus", StartAt: start, EndAt: start.Add(time.Hour), Name: "Free Electricity"}
+
+	result := mergeEvents([]Event{feed}, []Event{octopus})
+
+	if result[0].IsTest == nil || !*result[0].IsTest {
+		t.Error("Expected the feed's test flag to survive the Octopus event")
+	}
+	if result[0].Name != "Free Electricity" || result[0].Source != "octopus" {
+		t.Errorf("Expected the Octopus report to win otherwise, got %+v", result[0])
+	}
+}
+
+func TestMergePolicy_Strategies(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	points := func(n int) *int { return &n }
+	feed := Event{Source: "david_kendall", StartAt: start, Name: "Feed name", OctoPointsPerKwh: points(10), IsTest: boolPtr(true)}
+	octopus := Event{Source: "octopus", StartAt: start, Name: "Octopus name", OctoPointsPerKwh: points(20), IsTest: boolPtr(false)}
+
+	policy, err := newMergePolicy(map[string]string{
+		"name":             "prefer:david_kendall",
+		"octoPointsPerKwh": "earliest",
+		"isTest":           "priority",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	merged := policy.merge(feed, octopus)
+	if merged.Name != "Feed name" {
+		t.Errorf("Expected preferred source's name, got %q", merged.Name)
+	}
+	if *merged.OctoPointsPerKwh != 10 {
+		t.Errorf("Expected earliest points, got %d", *merged.OctoPointsPerKwh)
+	}
+	if *merged.IsTest {
+		t.Error("Expected the higher priority test flag")
+	}
+
+	if merged := defaultMergePolicy().merge(feed, octopus); !*merged.IsTest {
+		t.Error("Expected test flags to be combined with or by default")
+	}
+}
+
+func TestNewMergePolicy_Invalid(t *testing.T) {
+	tests := []map[string]string{
+		{"colour": "priority"},
+		{"name": "or"},
+		{"name": "prefer:nowhere"},
+		{"isTest": "newest"},
+	}
+
+	for _, config := range tests {
+		if _, err := newMergePolicy(config); err == nil {
+			t.Errorf("Expected error for %v", config)
+		}
+	}
+}
+
+func TestMergePolicy_EarliestUsesProvenance(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	points := func(n int) *int { return &n }
+	policy, err := newMergePolicy(map[string]string{"octoPointsPerKwh": "earliest"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	octopus := Event{Source: "octopus", StartAt: start, OctoPointsPerKwh: points(20),
+		Provenance: []Provenance{{Source: "octopus", FirstSeen: start.Add(-time.Hour)}}}
+	feed := Event{Source: "david_kendall", StartAt: start, OctoPointsPerKwh: points(10),
+		Provenance: []Provenance{{Source: "david_kendall", FirstSeen: start.Add(-2 * time.Hour)}}}
+
+	// The feed was merged second but reported the event first
+	if merged := policy.merge(octopus, feed); *merged.OctoPointsPerKwh != 10 {
+		t.Errorf("Expected the first seen source's points, got %d", *merged.OctoPointsPerKwh)
+	}
+	if merged := policy.merge(feed, octopus); *merged.OctoPointsPerKwh != 10 {
+		t.Errorf("Expected the first seen source's points whatever the merge order, got %d", *merged.OctoPointsPerKwh)
+	}
+}
+
+func TestUpdateCampaign_KeepsFeedTestFlag(t *testing.T) {
+	dir := t.TempDir()
+	outputFile := filepath.Join(dir, "free_electricity.json")
+	config := &Config{StateFile: filepath.Join(dir, "state.json")}
+	campaign := CampaignConfig{Slug: defaultCampaignSlug, OutputFile: outputFile}
+
+	start := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	window := Event{Code: "1", StartAt: start, EndAt: start.Add(time.Hour)}
+	if err := saveEvents([]Event{window}, outputFile); err != nil {
+		t.Fatalf("Failed to save events: %v", err)
+	}
+	run := func() {
+		feed, octopus := window, window
+		feed.IsTest = boolPtr(true)
+		octopus.Code = "OCT-1"
+		results := []sourceResult{
+			{name: "david_kendall", events: []Event{feed}},
+			{name: "octopus", events: []Event{octopus}},
+		}
+		if err := updateCampaign(context.Background(), config, campaign, results); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// The second run sees the same windows and sources as the file
+	run()
+	run()
+
+	events, err := loadExistingEvents(outputFile)
+	if err != nil {
+		t.Fatalf("Failed to load events: %v", err)
+	}
+	if len(events) != 1 || events[0].IsTest == nil || !*events[0].IsTest {
+		t.Errorf("Expected the feed's test flag to be written, got %+v", events)
+	}
+}
@@ -17,29 +17,34 @@
 package main
 
 import (
+	"log/slog"
+	"reflect"
 	"sort"
 	"strings"
 	"time"
 )
 
-// hasChanges checks if there are any changes between existing and new events
+// hasChanges checks if there are any changes between existing and new
+// events, comparing every output field except the code, which is assigned
+// after merging
 func hasChanges(existing, new []Event) bool {
 	if len(existing) != len(new) {
 		return true
 	}
 
-	// Create a map of existing events by their unique key (start+end time)
-	existingMap := make(map[string]bool)
-	for _, event := range existing {
-		key := event.StartAt.Format(time.RFC3339) + "_" + event.EndAt.Format(time.RFC3339)
-		existingMap[key] = true
+	// Index the existing output by its unique key (start+end time)
+	existingMap := make(map[string]OutputEvent, len(existing))
+	for _, event := range convertToOutputFormat(existing).Data {
+		event.Code = ""
+		existingMap[event.Start+"_"+event.End] = event
 	}
 
-	// Check if any new events are missing from existing
-	for _, event := range new {
-		key := event.StartAt.Format(time.RFC3339) + "_" + event.EndAt.Format(time.RFC3339)
-		if !existingMap[key] {
-			return true // Found a new event
+	// Check if any new event is missing from existing or differs from it
+	for _, event := range convertToOutputFormat(new).Data {
+		event.Code = ""
+		previous, ok := existingMap[event.Start+"_"+event.End]
+		if !ok || !reflect.DeepEqual(previous, event) {
+			return true // Found a new or updated event
 		}
 	}
 
@@ -47,7 +52,14 @@ func hasChanges(existing, new []Event) bool {
 }
 
 // mergeEvents merges existing and new events, deduplicating by start+end time
+// and combining fields with the default merge policy
 func mergeEvents(existing, new []Event) []Event {
+	return mergeEventsWithPolicy(existing, new, defaultMergePolicy())
+}
+
+// mergeEventsWithPolicy merges existing and new events, deduplicating by
+// start+end time. When both report a window, fields are combined using the policy
+func mergeEventsWithPolicy(existing, new []Event, policy mergePolicy) []Event {
 	// Pre-allocate map with estimated capacity
 	capacity := len(existing) + len(new)
 	eventMap := make(map[string]Event, capacity)
@@ -68,6 +80,18 @@ func mergeEvents(existing, new []Event) []Event {
 		keyBuilder.WriteString(event.StartAt.Format(time.RFC3339))
 		keyBuilder.WriteByte('_')
 		keyBuilder.WriteString(event.EndAt.Format(time.RFC3339))
+		if previous, ok := eventMap[keyBuilder.String()]; ok {
+			event = policy.merge(previous, event)
+			// Sources do not know where an event used to be, so keep its history
+			if event.History == nil {
+				event.History = previous.History
+			}
+			event.Provenance = mergeProvenance(previous.Provenance, event.Provenance)
+			// Only an authoritative source can bring a cancelled event back
+			if previous.Cancelled && !sourceRegistry[event.Source].authoritative {
+				event.Cancelled, event.CancelledAt = true, previous.CancelledAt
+			}
+		}
 		eventMap[keyBuilder.String()] = event
 		builderPool.Put(keyBuilder)
 	}
@@ -84,3 +108,195 @@ func mergeEvents(existing, new []Event) []Event {
 
 	return merged
 }
+
+// reconcileRescheduled moves events that a source now reports at a different
+// time to their new window, recording the previous window in their history
+// so the stale window does not survive as a separate event. An event has
+// moved when the source reports a new window with the same upstream code,
+// for sources with stable codes, or otherwise a new window overlapping the
+// old one within the tolerance. Candidates are events the source has ever
+// reported, whichever source reported them last, and events the source still
+// reports are left alone. Fetched events that sit in a window an event has
+// already moved away from are dropped for sources without stable codes, so
+// a feed that lags behind a reschedule does not bring the old window back
+func reconcileRescheduled(existing, fetched []Event, tolerance time.Duration) ([]Event, []Event) {
+	if len(fetched) == 0 {
+		return existing, fetched
+	}
+	source := fetched[0].Source
+	stableCodes := sourceRegistry[source].stableCodes
+
+	fetchedWindows := make(map[string]bool, len(fetched))
+	for _, event := range fetched {
+		fetchedWindows[windowKey(event)] = true
+	}
+	existingWindows := make(map[string]bool, len(existing))
+	previousWindows := make(map[string]bool)
+	for _, event := range existing {
+		existingWindows[windowKey(event)] = true
+		for _, window := range event.History {
+			previousWindows[windowKey(Event{StartAt: window.StartAt, EndAt: window.EndAt})] = true
+		}
+	}
+
+	reconciled := make([]Event, len(existing))
+	copy(reconciled, existing)
+	moved := make(map[int]bool)
+
+	for _, event := range fetched {
+		if existingWindows[windowKey(event)] {
+			continue
+		}
+
+		match := -1
+		for i, candidate := range reconciled {
+			if moved[i] || !candidate.reportedBy(source) || fetchedWindows[windowKey(candidate)] {
+				continue
+			}
+			// Where both codes are known they decide on their own
+			candidateCode := candidate.upstreamCode(source)
+			if stableCodes && candidateCode != "" && event.UpstreamCode != "" {
+				if candidateCode == event.UpstreamCode {
+					match = i
+					break
+				}
+				continue
+			}
+			if match < 0 && tolerance >= 0 && windowsOverlap(candidate, event, tolerance) {
+				match = i
+			}
+		}
+		if match < 0 {
+			continue
+		}
+
+		stale := reconciled[match]
+		slog.Info("Event rescheduled",
+			"source", source, "code", stale.Code,
+			"previous_start", stale.StartAt, "previous_end", stale.EndAt,
+			"start", event.StartAt, "end", event.EndAt)
+
+		history := make([]EventWindow, len(stale.History), len(stale.History)+1)
+		copy(history, stale.History)
+		stale.History = append(history, EventWindow{StartAt: stale.StartAt, EndAt: stale.EndAt})
+		stale.StartAt, stale.EndAt = event.StartAt, event.EndAt
+		reconciled[match] = stale
+		moved[match] = true
+	}
+
+	if stableCodes || len(previousWindows) == 0 {
+		return reconciled, fetched
+	}
+
+	current := make([]Event, 0, len(fetched))
+	for _, event := range fetched {
+		if previousWindows[windowKey(event)] && !existingWindows[windowKey(event)] {
+			slog.Info("Ignoring event in a window it was rescheduled from",
+				"source", source, "start", event.StartAt, "end", event.EndAt)
+			continue
+		}
+		current = append(current, event)
+	}
+	return reconciled, current
+}
+
+// rescheduleTolerance returns the configured tolerance for matching moved events
+func rescheduleTolerance(config *Config) time.Duration {
+	if config.Reconcile.Tolerance == 0 {
+		return defaultRescheduleTolerance
+	}
+	return config.Reconcile.Tolerance
+}
+
+// windowsOverlap reports whether two events overlap once each window is
+// widened by the tolerance
+func windowsOverlap(a, b Event, tolerance time.Duration) bool {
+	return a.StartAt.Before(b.EndAt.Add(tolerance)) && b.StartAt.Before(a.EndAt.Add(tolerance))
+}
+
+// authoritativeSource returns the source whose listing of a campaign decides
+// whether its events still exist
+func authoritativeSource(campaign string) string {
+	if campaign == savingSessionsCampaign {
+		return "saving_sessions"
+	}
+	return "octopus"
+}
+
+// trackCancellations counts, for each future event the campaign's
+// authoritative source reported before, the consecutive runs it has been
+// missing from that source, and marks it cancelled once the threshold is
+// reached. Cancelled events stay in the output so the event count never
+// falls. Counts for the campaign are replaced in missing, keyed by campaign
+// and window, and left unchanged when the source failed this run, for every
+// campaign or just this one, or reported the account as not enrolled
+func trackCancellations(campaign string, existing, merged []Event, results []sourceResult, missing map[string]int, threshold int, now time.Time) []Event {
+	source := authoritativeSource(campaign)
+	succeeded := false
+	reported := make(map[string]bool)
+	for _, result := range results {
+		if result.name != source || result.err != nil || result.campaignErrs[campaign] != nil {
+			continue
+		}
+		// An account outside the campaign sees no events, not cancelled ones
+		if enrolled, ok := result.enrollment[campaign]; ok && !enrolled {
+			continue
+		}
+		succeeded = true
+		for _, event := range campaignEvents(result.events, campaign) {
+			reported[windowKey(event)] = true
+		}
+	}
+	if !succeeded {
+		return merged
+	}
+
+	prefix := campaign + "/"
+	previous := make(map[string]int)
+	for key, count := range missing {
+		if strings.HasPrefix(key, prefix) {
+			previous[strings.TrimPrefix(key, prefix)] = count
+			delete(missing, key)
+		}
+	}
+
+	tracked := make(map[string]bool)
+	for _, event := range existing {
+		if event.reportedBy(source) {
+			tracked[windowKey(event)] = true
+		}
+	}
+
+	for i, event := range merged {
+		key := windowKey(event)
+		if event.Cancelled || !event.StartAt.After(now) || reported[key] {
+			continue
+		}
+		if !tracked[key] && previous[key] == 0 {
+			continue
+		}
+
+		count := previous[key] + 1
+		if count < threshold {
+			missing[prefix+key] = count
+			continue
+		}
+
+		slog.Info("Event cancelled",
+			"campaign", campaign, "start", event.StartAt, "end", event.EndAt, "missed_runs", count)
+		cancelledAt := now
+		merged[i].Cancelled = true
+		merged[i].CancelledAt = &cancelledAt
+	}
+
+	return merged
+}
+
+// cancellationThreshold returns the configured number of missed runs before
+// an event is cancelled
+func cancellationThreshold(config *Config) int {
+	if config.Cancellation.MissedRuns <= 0 {
+		return defaultCancellationMissedRuns
+	}
+	return config.Cancellation.MissedRuns
+}
@@ -0,0 +1,534 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// mockFixtures drives the mock server: the events it serves and the faults it injects
+type mockFixtures struct {
+	TokenLifetime time.Duration           `yaml:"tokenLifetime"`
+	Campaigns     map[string]mockCampaign `yaml:"campaigns"`
+	Feed          []mockEvent             `yaml:"feed"`
+	Faults        mockFaults              `yaml:"faults"`
+}
+
+// mockCampaign is a flexibility campaign served by the mock Kraken endpoint
+type mockCampaign struct {
+	Enrolled bool        `yaml:"enrolled"`
+	Events   []mockEvent `yaml:"events"`
+}
+
+// mockEvent is a single fixture event. Times are RFC3339
+type mockEvent struct {
+	Code        string `yaml:"code"`
+	Name        string `yaml:"name"`
+	Start       string `yaml:"start"`
+	End         string `yaml:"end"`
+	Participant bool   `yaml:"participant"`
+}
+
+// mockFaults are failures injected to exercise error handling
+type mockFaults struct {
+	// ServerErrors answers the first N requests with HTTP 500
+	ServerErrors int `yaml:"serverErrors"`
+	// Delay is added before every response
+	Delay time.Duration `yaml:"delay"`
+	// TokenUses rejects a token as expired after it has been used N times
+	TokenUses int `yaml:"tokenUses"`
+}
+
+// mockToken tracks a token issued by the mock server
+type mockToken struct {
+	expiry time.Time
+	uses   int
+}
+
+// mockServer is a stand-in for the Kraken GraphQL API and David Kendall's feed
+type mockServer struct {
+	fixtures mockFixtures
+	mutex    sync.Mutex
+	requests int
+	issued   int
+	tokens   map[string]*mockToken
+	refresh  map[string]bool
+}
+
+func newMockServer(fixtures mockFixtures) *mockServer {
+	if fixtures.TokenLifetime <= 0 {
+		fixtures.TokenLifetime = time.Hour
+	}
+	return &mockServer{
+		fixtures: fixtures,
+		tokens:   make(map[string]*mockToken),
+		refresh:  make(map[string]bool),
+	}
+}
+
+// defaultMockFixtures serves one past and two upcoming free electricity events
+func defaultMockFixtures(now time.Time) mockFixtures {
+	day := now.UTC().Truncate(24 * time.Hour)
+	event := func(code string, offset time.Duration) mockEvent {
+		start := day.Add(offset)
+		return mockEvent{
+			Code:  code,
+			Name:  "Free Electricity " + code,
+			Start: start.Format(time.RFC3339),
+			End:   start.Add(time.Hour).Format(time.RFC3339),
+		}
+	}
+
+	return mockFixtures{
+		Campaigns: map[string]mockCampaign{
+			defaultCampaignSlug: {
+				Enrolled: true,
+				Events: []mockEvent{
+					event("MOCK-1", -36*time.Hour),
+					event("MOCK-2", 36*time.Hour),
+					event("MOCK-3", 60*time.Hour),
+				},
+			},
+		},
+		Feed: []mockEvent{
+			event("1", -84*time.Hour),
+			event("2", -36*time.Hour),
+		},
+	}
+}
+
+// loadMockFixtures reads a YAML fixtures file
+func loadMockFixtures(filename string) (mockFixtures, error) {
+	var fixtures mockFixtures
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fixtures, err
+	}
+	if err := yaml.Unmarshal(data, &fixtures); err != nil {
+		return fixtures, fmt.Errorf("failed to parse fixtures: %w", err)
+	}
+	return fixtures, nil
+}
+
+// runMockServer implements the mock-server command
+func runMockServer(args []string) error {
+	flags := flag.NewFlagSet("mock-server", flag.ExitOnError)
+	addr := flags.String("addr", "127.0.0.1:8080", "Address to listen on")
+	fixturesFile := flags.String("fixtures", "", "YAML fixtures file (built-in fixtures if empty)")
+	flags.Parse(args)
+
+	fixtures := defaultMockFixtures(time.Now())
+	if *fixturesFile != "" {
+		var err error
+		if fixtures, err = loadMockFixtures(*fixturesFile); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{Addr: *addr, Handler: newMockServer(fixtures).handler()}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("Mock server listening",
+		"graphql", "http://"+*addr+"/graphql",
+		"feed", "http://"+*addr+"/free_electricity.json")
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// handler serves the mock GraphQL endpoint and feed, injecting configured faults
+func (m *mockServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/graphql", m.handleGraphQL)
+	mux.HandleFunc("/graphql/", m.handleGraphQL)
+	mux.HandleFunc("/free_electricity.json", m.handleFeed)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.mutex.Lock()
+		m.requests++
+		failing := m.requests <= m.fixtures.Faults.ServerErrors
+		m.mutex.Unlock()
+
+		if delay := m.fixtures.Faults.Delay; delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if failing {
+			http.Error(w, "mock server error", http.StatusInternalServerError)
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// handleFeed serves the fixture feed in David Kendall's format with ETag support
+func (m *mockServer) handleFeed(w http.ResponseWriter, r *http.Request) {
+	output := OutputData{Data: make([]OutputEvent, 0, len(m.fixtures.Feed))}
+	for _, event := range m.fixtures.Feed {
+		start, end, err := event.times()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		output.Data = append(output.Data, OutputEvent{
+			Start: start.Format("2006-01-02T15:04:05.000Z"),
+			End:   end.Format("2006-01-02T15:04:05.000Z"),
+			Code:  event.Code,
+		})
+	}
+
+	body, _ := json.Marshal(output)
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// handleGraphQL answers the queries and mutations octoevents sends
+func (m *mockServer) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string                 `json:"query"`
+		Variables map[string]interface{} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if strings.Contains(req.Query, "obtainKrakenToken") {
+		m.obtainToken(w, req.Variables)
+		return
+	}
+
+	if code, message := m.authenticate(r.Header.Get("Authorization")); code != "" {
+		writeMockError(w, code, "AUTHORIZATION", message)
+		return
+	}
+
+	switch {
+	case strings.Contains(req.Query, "__type(") || strings.Contains(req.Query, "__schema"):
+		m.introspect(w, req.Query)
+	case strings.Contains(req.Query, "joinCustomerFlexibilityCampaignEvent"):
+		m.joinEvent(w, req.Variables)
+	case strings.Contains(req.Query, "customerFlexibilityCampaignEvents"):
+		m.campaignEvents(w, req.Query, req.Variables)
+	default:
+		writeMockError(w, "KT-CT-1113", "VALIDATION", "Query is not supported by the mock server.")
+	}
+}
+
+// obtainToken issues a token for any API key or a refresh token issued earlier
+func (m *mockServer) obtainToken(w http.ResponseWriter, variables map[string]interface{}) {
+	input, _ := variables["input"].(map[string]interface{})
+	if refreshToken, ok := input["refreshToken"].(string); ok && !m.refresh[refreshToken] {
+		writeMockError(w, "KT-CT-1111", "AUTHORIZATION", "Invalid refresh token.")
+		return
+	}
+
+	m.issued++
+	expiry := time.Now().Add(m.fixtures.TokenLifetime)
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"exp":%d,"jti":%d}`, expiry.Unix(), m.issued)))
+	token := header + "." + payload + ".mock"
+	refreshToken := fmt.Sprintf("mock-refresh-%d", m.issued)
+
+	m.tokens[token] = &mockToken{expiry: expiry}
+	m.refresh[refreshToken] = true
+
+	writeMockData(w, map[string]interface{}{
+		"obtainKrakenToken": map[string]interface{}{
+			"token":            token,
+			"refreshToken":     refreshToken,
+			"refreshExpiresIn": time.Now().Add(7 * 24 * time.Hour).Unix(),
+		},
+	})
+}
+
+// mockTypeAlias matches the aliased __type lookups in schema-check's query
+var mockTypeAlias = regexp.MustCompile(`(\w+): __type\(name: "(\w+)"\)`)
+
+// introspect answers schema-check's introspection queries from the embedded
+// schema expectation, so the mock never reports drift
+func (m *mockServer) introspect(w http.ResponseWriter, query string) {
+	var snapshot map[string]string
+	if err := json.Unmarshal(expectedSchemaJSON, &snapshot); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	types := mockSchemaTypes(snapshot)
+
+	data := make(map[string]interface{})
+	for _, match := range mockTypeAlias.FindAllStringSubmatch(query, -1) {
+		data[match[1]] = types[match[2]]
+	}
+	if strings.Contains(query, "__schema") {
+		data["__schema"] = map[string]interface{}{
+			"queryType":    types[schemaRootQuery],
+			"mutationType": types[schemaRootMutation],
+		}
+	}
+
+	writeMockData(w, data)
+}
+
+// mockSchemaTypes rebuilds introspection types from a flattened snapshot
+func mockSchemaTypes(snapshot map[string]string) map[string]*introspectionType {
+	keys := make([]string, 0, len(snapshot))
+	for key := range snapshot {
+		keys = append(keys, key)
+	}
+	// Fields sort before their arguments
+	sort.Strings(keys)
+
+	types := make(map[string]*introspectionType)
+	for _, key := range keys {
+		typeName, fieldName, _ := strings.Cut(key, ".")
+		t, ok := types[typeName]
+		if !ok {
+			t = &introspectionType{Name: typeName, Fields: []introspectionField{}}
+			types[typeName] = t
+		}
+
+		ref := mockTypeRef(snapshot[key])
+		if field, arg, isArg := strings.Cut(fieldName, "("); isArg {
+			for i := range t.Fields {
+				if t.Fields[i].Name == field {
+					t.Fields[i].Args = append(t.Fields[i].Args, introspectionInputValue{Name: strings.TrimSuffix(arg, ")"), Type: *ref})
+				}
+			}
+			continue
+		}
+		t.Fields = append(t.Fields, introspectionField{Name: fieldName, Args: []introspectionInputValue{}, Type: *ref})
+	}
+	return types
+}
+
+// mockTypeRef parses a type ref written in SDL notation
+func mockTypeRef(s string) *introspectionTypeRef {
+	switch {
+	case strings.HasSuffix(s, "!"):
+		return &introspectionTypeRef{Kind: "NON_NULL", OfType: mockTypeRef(strings.TrimSuffix(s, "!"))}
+	case strings.HasPrefix(s, "["):
+		return &introspectionTypeRef{Kind: "LIST", OfType: mockTypeRef(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))}
+	}
+	return &introspectionTypeRef{Kind: "OBJECT", Name: s}
+}
+
+// authenticate checks a token, returning an error code and message if it is rejected
+func (m *mockServer) authenticate(token string) (string, string) {
+	issued, ok := m.tokens[token]
+	if !ok {
+		return "KT-CT-1111", "Unauthorized."
+	}
+
+	issued.uses++
+	if time.Now().After(issued.expiry) || (m.fixtures.Faults.TokenUses > 0 && issued.uses > m.fixtures.Faults.TokenUses) {
+		return "KT-CT-1124", "Signature of the JWT has expired."
+	}
+	return "", ""
+}
+
+// joinEvent marks a fixture event as joined
+func (m *mockServer) joinEvent(w http.ResponseWriter, variables map[string]interface{}) {
+	input, _ := variables["input"].(map[string]interface{})
+	slug, _ := input["campaignSlug"].(string)
+	code, _ := input["eventCode"].(string)
+
+	campaign, ok := m.fixtures.Campaigns[slug]
+	if ok {
+		for i, event := range campaign.Events {
+			if event.Code == code {
+				campaign.Events[i].Participant = true
+				writeMockData(w, map[string]interface{}{
+					"joinCustomerFlexibilityCampaignEvent": map[string]interface{}{"isEventParticipant": true},
+				})
+				return
+			}
+		}
+	}
+
+	writeMockError(w, "KT-CT-4178", "NOT_FOUND", "Event not found.")
+}
+
+// campaignEvents answers the aliased enrollment and events query, one alias
+// pair per slug variable
+func (m *mockServer) campaignEvents(w http.ResponseWriter, query string, variables map[string]interface{}) {
+	pageSize := octopusPageSize
+	if size, ok := variables["pageSize"].(float64); ok && size > 0 {
+		pageSize = int(size)
+	}
+	backwards := strings.Contains(query, "last: $pageSize")
+
+	data := make(map[string]interface{})
+	for name, value := range variables {
+		if !strings.HasPrefix(name, "slug") {
+			continue
+		}
+		index := strings.TrimPrefix(name, "slug")
+		slug, _ := value.(string)
+		cursor, _ := variables["cursor"+index].(string)
+
+		campaign, ok := m.fixtures.Campaigns[slug]
+		if !ok {
+			writeMockError(w, "KT-CT-4178", "NOT_FOUND", fmt.Sprintf("Campaign %s not found.", slug))
+			return
+		}
+
+		connection, err := mockEventPage(campaign.Events, pageSize, cursor, backwards)
+		if err != nil {
+			writeMockError(w, "KT-CT-1605", "VALIDATION", err.Error())
+			return
+		}
+
+		data["enrolled"+index] = campaign.Enrolled
+		data["events"+index] = connection
+	}
+
+	writeMockData(w, data)
+}
+
+// mockEventPage returns one page of events ordered by start time, using event
+// codes as cursors
+func mockEventPage(fixtures []mockEvent, pageSize int, cursor string, backwards bool) (EventConnection, error) {
+	events := make([]Event, 0, len(fixtures))
+	for _, fixture := range fixtures {
+		start, end, err := fixture.times()
+		if err != nil {
+			return EventConnection{}, err
+		}
+		events = append(events, Event{
+			Code:               fixture.Code,
+			Name:               fixture.Name,
+			StartAt:            start,
+			EndAt:              end,
+			IsEventParticipant: fixture.Participant,
+			Typename:           "CustomerFlexibilityCampaignEvent",
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].StartAt.Before(events[j].StartAt) })
+
+	position := -1
+	for i, event := range events {
+		if event.Code == cursor {
+			position = i
+		}
+	}
+	if cursor != "" && position < 0 {
+		return EventConnection{}, fmt.Errorf("unknown cursor %q", cursor)
+	}
+
+	from, to := position+1, position+1+pageSize
+	if backwards {
+		to = len(events)
+		if cursor != "" {
+			to = position
+		}
+		from = to - pageSize
+	}
+	from, to = max(from, 0), min(to, len(events))
+
+	connection := EventConnection{
+		Edges:      make([]EventEdge, 0, to-from),
+		TotalCount: len(events),
+		EdgeCount:  to - from,
+		PageInfo: PageInfo{
+			HasNextPage:     to < len(events),
+			HasPreviousPage: from > 0,
+		},
+	}
+	for _, event := range events[from:to] {
+		connection.Edges = append(connection.Edges, EventEdge{Cursor: event.Code, Node: event})
+	}
+	if len(connection.Edges) > 0 {
+		connection.PageInfo.StartCursor = connection.Edges[0].Cursor
+		connection.PageInfo.EndCursor = connection.Edges[len(connection.Edges)-1].Cursor
+	}
+
+	return connection, nil
+}
+
+// times parses the fixture's start and end
+func (e mockEvent) times() (time.Time, time.Time, error) {
+	start, err := time.Parse(time.RFC3339, e.Start)
+	if err != nil {
+		return start, start, fmt.Errorf("invalid start for event %s: %w", e.Code, err)
+	}
+	end, err := time.Parse(time.RFC3339, e.End)
+	if err != nil {
+		return start, end, fmt.Errorf("invalid end for event %s: %w", e.Code, err)
+	}
+	return start.UTC(), end.UTC(), nil
+}
+
+func writeMockData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func writeMockError(w http.ResponseWriter, code, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": nil,
+		"errors": []interface{}{map[string]interface{}{
+			"message":    message,
+			"extensions": map[string]interface{}{"errorCode": code, "errorType": errorType},
+		}},
+	})
+}
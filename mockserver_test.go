@@ -0,0 +1,178 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// chdirTemp runs the test from a temporary directory so the cache written
+// by a full run does not leak between tests
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	original, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(original) })
+
+	return dir
+}
+
+// mockRunConfig points a full run at the mock server
+func mockRunConfig(dir, serverURL string) *Config {
+	return &Config{
+		AccountNumber: "A-12345678",
+		MeterPointID:  "1000000000000",
+		APIKey:        "sk_live_test_key",
+		OutputFile:    filepath.Join(dir, "free_electricity.json"),
+		StateFile:     filepath.Join(dir, "state.json"),
+		CircuitBreaker: CircuitBreakerConfig{
+			HealthFile: filepath.Join(dir, "health.json"),
+		},
+		Retry: RetryConfig{BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+		Endpoints: EndpointsConfig{
+			GraphQL:      serverURL + "/graphql",
+			DavidKendall: serverURL + "/free_electricity.json",
+		},
+	}
+}
+
+func TestMockServer_EndToEnd(t *testing.T) {
+	dir := chdirTemp(t)
+	server := httptest.NewServer(newMockServer(defaultMockFixtures(time.Now())).handler())
+	defer server.Close()
+
+	config := mockRunConfig(dir, server.URL)
+	if err := fetchAndUpdateEvents(context.Background(), config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	events, err := loadExistingEvents(config.OutputFile)
+	if err != nil {
+		t.Fatalf("Failed to load output: %v", err)
+	}
+	// Four distinct windows: two feed events, one shared with Octopus, and
+	// two upcoming Octopus events
+	if len(events) != 4 {
+		t.Errorf("Expected 4 events, got %d", len(events))
+	}
+}
+
+func TestMockServer_Faults(t *testing.T) {
+	dir := chdirTemp(t)
+	fixtures := defaultMockFixtures(time.Now())
+	fixtures.Faults = mockFaults{ServerErrors: 1, TokenUses: 1}
+
+	// Enough events for a second page, which needs a renewed token
+	campaign := fixtures.Campaigns[defaultCampaignSlug]
+	base := time.Now().UTC().Truncate(time.Hour).Add(-48 * time.Hour)
+	for i := 0; i < octopusPageSize; i++ {
+		start := base.Add(-time.Duration(i+1) * 24 * time.Hour)
+		campaign.Events = append(campaign.Events, mockEvent{
+			Code:  fmt.Sprintf("PAGE-%d", i),
+			Start: start.Format(time.RFC3339),
+			End:   start.Add(time.Hour).Format(time.RFC3339),
+		})
+	}
+	fixtures.Campaigns[defaultCampaignSlug] = campaign
+	mock := newMockServer(fixtures)
+	server := httptest.NewServer(mock.handler())
+	defer server.Close()
+
+	// Fetch sources one at a time so each request sees the faults in turn
+	config := mockRunConfig(dir, server.URL)
+	config.MaxConcurrentFetches = 1
+	config.Campaigns = []CampaignConfig{{Slug: defaultCampaignSlug, OutputFile: config.OutputFile}}
+
+	if err := fetchAndUpdateEvents(context.Background(), config); err != nil {
+		t.Fatalf("Expected run to recover from a server error and an expired token, got %v", err)
+	}
+	if mock.issued < 2 {
+		t.Errorf("Expected the expired token to be renewed, got %d tokens issued", mock.issued)
+	}
+
+	events, err := loadExistingEvents(config.OutputFile)
+	if err != nil {
+		t.Fatalf("Failed to load output: %v", err)
+	}
+	if len(events) < octopusPageSize+3 {
+		t.Errorf("Expected events from both pages, got %d", len(events))
+	}
+}
+
+func TestMockServer_SlowResponses(t *testing.T) {
+	dir := chdirTemp(t)
+	fixtures := defaultMockFixtures(time.Now())
+	fixtures.Faults.Delay = 200 * time.Millisecond
+	server := httptest.NewServer(newMockServer(fixtures).handler())
+	defer server.Close()
+
+	config := mockRunConfig(dir, server.URL)
+	config.Sources = map[string]SourceConfig{
+		"octopus":       {Timeout: 50 * time.Millisecond},
+		"david_kendall": {Timeout: 50 * time.Millisecond},
+	}
+
+	start := time.Now()
+	if err := fetchAndUpdateEvents(context.Background(), config); err != nil {
+		t.Fatalf("Expected timeouts only to warn, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Expected slow sources to be abandoned, run took %v", time.Since(start))
+	}
+	if _, err := os.Stat(config.OutputFile); !os.IsNotExist(err) {
+		t.Error("Expected no output when every source timed out")
+	}
+}
+
+func TestMockEventPage(t *testing.T) {
+	fixtures := defaultMockFixtures(time.Now()).Campaigns[defaultCampaignSlug].Events
+
+	first, err := mockEventPage(fixtures, 2, "", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(first.Edges) != 2 || !first.PageInfo.HasNextPage || first.PageInfo.EndCursor != "MOCK-2" {
+		t.Errorf("Unexpected first page %+v", first.PageInfo)
+	}
+
+	second, _ := mockEventPage(fixtures, 2, first.PageInfo.EndCursor, false)
+	if len(second.Edges) != 1 || second.PageInfo.HasNextPage || second.Edges[0].Node.Code != "MOCK-3" {
+		t.Errorf("Unexpected second page %+v", second.PageInfo)
+	}
+
+	last, _ := mockEventPage(fixtures, 2, "", true)
+	if len(last.Edges) != 2 || !last.PageInfo.HasPreviousPage || last.PageInfo.StartCursor != "MOCK-2" {
+		t.Errorf("Unexpected last page %+v", last.PageInfo)
+	}
+
+	if _, err := mockEventPage(fixtures, 2, "MISSING", false); err == nil {
+		t.Error("Expected error for unknown cursor, got nil")
+	}
+}
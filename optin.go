@@ -0,0 +1,255 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/machinebox/graphql"
+	"github.com/pkg/errors"
+)
+
+// Opt-in outcomes recorded in the state file
+const (
+	optInJoined = "joined"
+	optInFailed = "failed"
+	optInDryRun = "dry_run"
+)
+
+const joinCampaignEventMutation = `
+	mutation joinCustomerFlexibilityCampaignEvent($input: JoinCustomerFlexibilityCampaignEventInput!) {
+		joinCustomerFlexibilityCampaignEvent(input: $input) {
+			isEventParticipant
+		}
+	}
+`
+
+// graphqlRunner executes GraphQL requests; AuthenticatedClient satisfies it
+type graphqlRunner interface {
+	Run(ctx context.Context, req *graphql.Request, resp interface{}) error
+}
+
+// singleAttemptRunner runs each request once on a client, skipping its retry
+// policy but sharing its token
+type singleAttemptRunner struct {
+	client *AuthenticatedClient
+}
+
+func (r singleAttemptRunner) Run(ctx context.Context, req *graphql.Request, resp interface{}) error {
+	return r.client.runAuthenticated(ctx, req, resp)
+}
+
+// JoinCampaignEventInput is the input to the join campaign event mutation
+type JoinCampaignEventInput struct {
+	AccountNumber         string `json:"accountNumber"`
+	CampaignSlug          string `json:"campaignSlug"`
+	EventCode             string `json:"eventCode"`
+	SupplyPointIdentifier string `json:"supplyPointIdentifier"`
+}
+
+// JoinCampaignEventMutation is the response to the join campaign event mutation
+type JoinCampaignEventMutation struct {
+	JoinCustomerFlexibilityCampaignEvent struct {
+		IsEventParticipant bool `json:"isEventParticipant"`
+	} `json:"joinCustomerFlexibilityCampaignEvent"`
+}
+
+// OptInRecord records the outcome of trying to join a single event
+type OptInRecord struct {
+	Campaign  string    `json:"campaign"`
+	EventCode string    `json:"eventCode"`
+	StartAt   time.Time `json:"startAt"`
+	EndAt     time.Time `json:"endAt"`
+	Outcome   string    `json:"outcome"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// runOptIn joins any upcoming Octopus events the account is not yet
+// participating in and records the outcomes in the state file
+func runOptIn(ctx context.Context, config *Config, client *AuthenticatedClient, results []sourceResult) error {
+	var events []Event
+	for _, result := range results {
+		if result.name == "octopus" && result.err == nil {
+			events = append(events, result.events...)
+		}
+	}
+
+	statePath := stateFilePath(config)
+	state, err := loadState(statePath)
+	if err != nil {
+		return errors.Wrap(err, "failed to load state")
+	}
+
+	// joinEvent applies the retry policy itself so it can record the attempts
+	records := joinPendingEvents(ctx, singleAttemptRunner{client}, config, events, state.OptIns, time.Now())
+	if len(records) == 0 {
+		slog.Info("No upcoming events need joining")
+		return nil
+	}
+
+	state.OptIns = recordOptIns(state.OptIns, records)
+	if err := saveState(statePath, state); err != nil {
+		return errors.Wrap(err, "failed to save opt-in outcomes")
+	}
+
+	markJoinedEvents(results, records)
+	return nil
+}
+
+// recordOptIns adds outcomes to the history, keeping one record per event.
+// A dry run or failed join that is tried again on a later run replaces its
+// earlier record, so repeated attempts do not push joins out of the history
+func recordOptIns(history, records []OptInRecord) []OptInRecord {
+	index := make(map[string]int, len(history))
+	for i, record := range history {
+		index[record.Campaign+"/"+record.EventCode] = i
+	}
+
+	for _, record := range records {
+		key := record.Campaign + "/" + record.EventCode
+		if i, ok := index[key]; ok {
+			history[i] = record
+			continue
+		}
+		index[key] = len(history)
+		history = append(history, record)
+	}
+	return history
+}
+
+// markJoinedEvents flags freshly joined events as participating so later
+// steps in the run see the account's current participation
+func markJoinedEvents(results []sourceResult, records []OptInRecord) {
+	joined := make(map[string]bool, len(records))
+	for _, record := range records {
+		if record.Outcome == optInJoined {
+			joined[record.Campaign+"/"+record.EventCode] = true
+		}
+	}
+
+	for r := range results {
+		for i, event := range results[r].events {
+			if joined[event.Campaign+"/"+event.Code] {
+				results[r].events[i].IsEventParticipant = true
+			}
+		}
+	}
+}
+
+// joinPendingEvents attempts to join every event that has not started, that
+// the account is not participating in and that has not already been joined
+func joinPendingEvents(ctx context.Context, runner graphqlRunner, config *Config, events []Event, history []OptInRecord, now time.Time) []OptInRecord {
+	joined := make(map[string]bool)
+	for _, record := range history {
+		if record.Outcome == optInJoined {
+			joined[record.Campaign+"/"+record.EventCode] = true
+		}
+	}
+
+	pending := make([]Event, 0, len(events))
+	for _, event := range events {
+		if event.IsEventParticipant || event.Code == "" || !event.StartAt.After(now) {
+			continue
+		}
+		if joined[event.Campaign+"/"+event.Code] {
+			continue
+		}
+		pending = append(pending, event)
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].StartAt.Before(pending[j].StartAt)
+	})
+
+	policy := optInRetryPolicy(config)
+
+	records := make([]OptInRecord, 0, len(pending))
+	for _, event := range pending {
+		record := OptInRecord{
+			Campaign:  event.Campaign,
+			EventCode: event.Code,
+			StartAt:   event.StartAt,
+			EndAt:     event.EndAt,
+			At:        now,
+		}
+
+		if config.OptIn.DryRun {
+			slog.Info("Would join event (dry run)",
+				"campaign", event.Campaign, "code", event.Code, "start", event.StartAt, "end", event.EndAt)
+			record.Outcome = optInDryRun
+			records = append(records, record)
+			continue
+		}
+
+		attempts, err := joinEvent(ctx, runner, config, event, policy)
+		record.Attempts = attempts
+		if err != nil {
+			slog.Warn("Failed to join event",
+				"campaign", event.Campaign, "code", event.Code, "attempts", attempts, "error", err)
+			record.Outcome = optInFailed
+			record.Error = err.Error()
+		} else {
+			slog.Info("Joined event",
+				"campaign", event.Campaign, "code", event.Code, "start", event.StartAt, "end", event.EndAt)
+			record.Outcome = optInJoined
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// optInRetryPolicy returns the shared retry policy, with the opt-in's own
+// number of attempts when one is configured
+func optInRetryPolicy(config *Config) retryPolicy {
+	policy := newRetryPolicy(config.Retry)
+	if config.OptIn.MaxAttempts > 0 {
+		policy.maxAttempts = config.OptIn.MaxAttempts
+	}
+	return policy
+}
+
+// joinEvent calls the join mutation, retrying transient failures with the
+// given policy, and returns the number of attempts made
+func joinEvent(ctx context.Context, runner graphqlRunner, config *Config, event Event, policy retryPolicy) (int, error) {
+	attempts := 0
+	err := policy.do(ctx, "join event", func() error {
+		attempts++
+		req := graphql.NewRequest(joinCampaignEventMutation)
+		req.Var("input", JoinCampaignEventInput{
+			AccountNumber:         config.AccountNumber,
+			CampaignSlug:          event.Campaign,
+			EventCode:             event.Code,
+			SupplyPointIdentifier: config.MeterPointID,
+		})
+
+		var response JoinCampaignEventMutation
+		if err := runner.Run(ctx, req, &response); err != nil {
+			return err
+		}
+		if !response.JoinCustomerFlexibilityCampaignEvent.IsEventParticipant {
+			return errors.New("event was not joined")
+		}
+		return nil
+	})
+	return attempts, err
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/machinebox/graphql"
+)
+
+// fakeRunner is a graphqlRunner that returns queued errors before succeeding
+type fakeRunner struct {
+	errs   []error
+	calls  int
+	joined bool
+}
+
+func (r *fakeRunner) Run(ctx context.Context, req *graphql.Request, resp interface{}) error {
+	r.calls++
+	if len(r.errs) > 0 {
+		err := r.errs[0]
+		r.errs = r.errs[1:]
+		return err
+	}
+
+	response := resp.(*JoinCampaignEventMutation)
+	response.JoinCustomerFlexibilityCampaignEvent.IsEventParticipant = r.joined
+	return nil
+}
+
+func testOptInEvents(now time.Time) []Event {
+	return []Event{
+		{Code: "past", Campaign: "free_electricity", StartAt: now.Add(-2 * time.Hour), EndAt: now.Add(-time.Hour)},
+		{Code: "joined", Campaign: "free_electricity", StartAt: now.Add(time.Hour), EndAt: now.Add(2 * time.Hour), IsEventParticipant: true},
+		{Code: "upcoming", Campaign: "free_electricity", StartAt: now.Add(3 * time.Hour), EndAt: now.Add(4 * time.Hour)},
+	}
+}
+
+func TestJoinPendingEvents_JoinsUpcoming(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	runner := &fakeRunner{joined: true}
+
+	records := joinPendingEvents(context.Background(), runner, testFetchConfig(), testOptInEvents(now), nil, now)
+
+	if runner.calls != 1 {
+		t.Errorf("Expected 1 join call, got %d", runner.calls)
+	}
+	if len(records) != 1 || records[0].EventCode != "upcoming" || records[0].Outcome != optInJoined {
+		t.Errorf("Unexpected opt-in records %+v", records)
+	}
+}
+
+func TestJoinPendingEvents_DryRun(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	runner := &fakeRunner{joined: true}
+	config := testFetchConfig()
+	config.OptIn.DryRun = true
+
+	records := joinPendingEvents(context.Background(), runner, config, testOptInEvents(now), nil, now)
+
+	if runner.calls != 0 {
+		t.Errorf("Expected no join calls in dry run, got %d", runner.calls)
+	}
+	if len(records) != 1 || records[0].Outcome != optInDryRun {
+		t.Errorf("Unexpected dry run records %+v", records)
+	}
+}
+
+func TestJoinPendingEvents_SkipsPreviouslyJoined(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	runner := &fakeRunner{joined: true}
+	history := []OptInRecord{{Campaign: "free_electricity", EventCode: "upcoming", Outcome: optInJoined}}
+
+	records := joinPendingEvents(context.Background(), runner, testFetchConfig(), testOptInEvents(now), history, now)
+
+	if runner.calls != 0 || len(records) != 0 {
+		t.Errorf("Expected previously joined event to be skipped, got %d calls and %+v", runner.calls, records)
+	}
+}
+
+func TestRecordOptIns_OnePerEvent(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	history := []OptInRecord{
+		{Campaign: "free_electricity", EventCode: "joined", Outcome: optInJoined, At: now.Add(-time.Hour)},
+		{Campaign: "free_electricity", EventCode: "upcoming", Outcome: optInFailed, Attempts: 3, At: now.Add(-time.Hour)},
+	}
+
+	// Two more runs: the failed join is tried again and a new event appears
+	for i := 0; i < 2; i++ {
+		history = recordOptIns(history, []OptInRecord{
+			{Campaign: "free_electricity", EventCode: "upcoming", Outcome: optInDryRun, At: now},
+			{Campaign: "free_electricity", EventCode: "later", Outcome: optInDryRun, At: now},
+		})
+	}
+
+	if len(history) != 3 {
+		t.Fatalf("Expected one record per event, got %+v", history)
+	}
+	if history[0].EventCode != "joined" || history[0].Outcome != optInJoined {
+		t.Errorf("Expected the joined record to be kept, got %+v", history[0])
+	}
+	if history[1].EventCode != "upcoming" || history[1].Outcome != optInDryRun || history[1].Attempts != 0 {
+		t.Errorf("Expected the retried event to be updated in place, got %+v", history[1])
+	}
+	if history[2].EventCode != "later" {
+		t.Errorf("Expected the new event to be appended, got %+v", history[2])
+	}
+}
+
+// testOptInRetryPolicy retries joins without waiting
+var testOptInRetryPolicy = retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+
+func TestJoinEvent_RetriesTransientFailures(t *testing.T) {
+	transient := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	runner := &fakeRunner{errs: []error{transient, transient}, joined: true}
+
+	attempts, err := joinEvent(context.Background(), runner, testFetchConfig(), Event{Code: "e1", Campaign: "free_electricity"}, testOptInRetryPolicy)
+	if err != nil {
+		t.Fatalf("Expected join to succeed after retries, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestJoinEvent_PermanentFailure(t *testing.T) {
+	runner := &fakeRunner{errs: []error{errors.New("graphql: event is full")}}
+
+	attempts, err := joinEvent(context.Background(), runner, testFetchConfig(), Event{Code: "e1", Campaign: "free_electricity"}, testOptInRetryPolicy)
+	if err == nil {
+		t.Fatal("Expected permanent failure, got nil")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected permanent failure not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestOptInRetryPolicy(t *testing.T) {
+	config := &Config{Retry: RetryConfig{MaxAttempts: 4, BaseDelay: time.Millisecond}}
+	if policy := optInRetryPolicy(config); policy.maxAttempts != 4 || policy.baseDelay != time.Millisecond {
+		t.Errorf("Expected the shared retry policy, got %+v", policy)
+	}
+
+	config.OptIn.MaxAttempts = 2
+	if policy := optInRetryPolicy(config); policy.maxAttempts != 2 {
+		t.Errorf("Expected the opt-in attempts to override the shared policy, got %d", policy.maxAttempts)
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// gspGroups maps GSP group identifiers to the region names Octopus uses
+var gspGroups = map[string]string{
+	"_A": "Eastern England",
+	"_B": "East Midlands",
+	"_C": "London",
+	"_D": "Merseyside and Northern Wales",
+	"_E": "West Midlands",
+	"_F": "North Eastern England",
+	"_G": "North Western England",
+	"_H": "Southern England",
+	"_J": "South Eastern England",
+	"_K": "Southern Wales",
+	"_L": "South Western England",
+	"_M": "Yorkshire",
+	"_N": "Southern Scotland",
+	"_P": "Northern Scotland",
+}
+
+// PowerUpEvent represents a single regional power-up in a feed
+type PowerUpEvent struct {
+	Start  string `json:"start"`
+	End    string `json:"end"`
+	Code   string `json:"code"`
+	Region string `json:"region"`
+}
+
+// PowerUpFeed represents a feed of power-ups in the OutputData shape
+type PowerUpFeed struct {
+	Data []PowerUpEvent `json:"data"`
+}
+
+// MeterPointDetails represents the Octopus REST meter point response
+type MeterPointDetails struct {
+	GSP  string `json:"gsp"`
+	MPAN string `json:"mpan"`
+}
+
+// powerUpsSource fetches regional power-ups and keeps those for the configured region
+type powerUpsSource struct {
+	config   *Config
+	client   *http.Client
+	priority int
+}
+
+func newPowerUpsSource(config *Config, clients sourceClients, priority int) EventSource {
+	return &powerUpsSource{config: config, client: clients.http, priority: priority}
+}
+
+func (s *powerUpsSource) Name() string { return "power_ups" }
+
+func (s *powerUpsSource) Priority() int { return s.priority }
+
+func (s *powerUpsSource) Fetch(ctx context.Context) ([]Event, error) {
+	return fetchPowerUps(ctx, s.config, s.client)
+}
+
+// normalizeRegion converts a GSP group ("_C", "C") or region name ("London")
+// into its canonical GSP group identifier, returning "" if unrecognised
+func normalizeRegion(region string) string {
+	region = strings.TrimSpace(region)
+	if region == "" {
+		return ""
+	}
+
+	group := strings.ToUpper(region)
+	if !strings.HasPrefix(group, "_") {
+		group = "_" + group
+	}
+	if _, ok := gspGroups[group]; ok {
+		return group
+	}
+
+	for group, name := range gspGroups {
+		if strings.EqualFold(name, region) {
+			return group
+		}
+	}
+
+	return ""
+}
+
+// fetchPowerUps loads the power-ups feed and returns the events for the user's region
+func fetchPowerUps(ctx context.Context, config *Config, client *http.Client) ([]Event, error) {
+	region, err := resolvePowerUpsRegion(ctx, config, client)
+	if err != nil {
+		return nil, err
+	}
+
+	feed, err := loadPowerUpFeed(ctx, client, config.PowerUps)
+	if err != nil {
+		return nil, err
+	}
+
+	events := filterPowerUps(feed, region)
+	slog.Info("Loaded power-ups", "region", region, "feed_count", len(feed.Data), "region_count", len(events))
+	return events, nil
+}
+
+// resolvePowerUpsRegion returns the configured region, looking it up from the
+// meter point when none is configured
+func resolvePowerUpsRegion(ctx context.Context, config *Config, client *http.Client) (string, error) {
+	if config.PowerUps.Region != "" {
+		region := normalizeRegion(config.PowerUps.Region)
+		if region == "" {
+			return "", fmt.Errorf("unknown power-ups region %q", config.PowerUps.Region)
+		}
+		return region, nil
+	}
+
+	if config.Endpoints.MeterPoints == "" {
+		return "", fmt.Errorf("brand %q cannot look up the region from the meter point, set powerUps.region", config.Brand)
+	}
+
+	region, err := lookupMeterPointRegion(ctx, client, config.Endpoints.MeterPoints, config.MeterPointID)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to determine region from meter point, set powerUps.region")
+	}
+
+	slog.Info("Detected power-ups region from meter point", "region", region)
+	return region, nil
+}
+
+// lookupMeterPointRegion asks the Octopus REST API which GSP group a meter point belongs to
+func lookupMeterPointRegion(ctx context.Context, client *http.Client, baseURL, meterPointID string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(baseURL, "/")+"/"+meterPointID+"/", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var details MeterPointDetails
+	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
+		return "", err
+	}
+
+	region := normalizeRegion(details.GSP)
+	if region == "" {
+		return "", fmt.Errorf("meter point reported unknown GSP group %q", details.GSP)
+	}
+
+	return region, nil
+}
+
+// loadPowerUpFeed reads the power-ups feed from a local file or URL. Neither
+// the Kraken GraphQL API nor the REST API exposes power-ups, so there is no
+// API to fall back on and the feed must be supplied
+func loadPowerUpFeed(ctx context.Context, client *http.Client, settings PowerUpsConfig) (PowerUpFeed, error) {
+	var feed PowerUpFeed
+	var reader io.Reader
+
+	switch {
+	case settings.File != "":
+		file, err := os.Open(settings.File)
+		if err != nil {
+			return feed, err
+		}
+		defer file.Close()
+		reader = file
+
+	case settings.URL != "":
+		req, err := http.NewRequestWithContext(ctx, "GET", settings.URL, nil)
+		if err != nil {
+			return feed, err
+		}
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return feed, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return feed, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		reader = resp.Body
+
+	default:
+		return feed, fmt.Errorf("no power-ups feed configured, set powerUps.url or powerUps.file")
+	}
+
+	if err := json.NewDecoder(reader).Decode(&feed); err != nil {
+		return feed, errors.Wrap(err, "failed to decode power-ups feed")
+	}
+
+	return feed, nil
+}
+
+// filterPowerUps converts feed entries to events, keeping those for the given
+// region. Entries without a region are national and always kept
+func filterPowerUps(feed PowerUpFeed, region string) []Event {
+	events := make([]Event, 0, len(feed.Data))
+	for _, powerUp := range feed.Data {
+		eventRegion := normalizeRegion(powerUp.Region)
+		if powerUp.Region != "" && eventRegion == "" {
+			slog.Warn("Skipping power-up with unknown region", "region", powerUp.Region, "start", powerUp.Start)
+			continue
+		}
+		if eventRegion != "" && eventRegion != region {
+			continue
+		}
+
+		startTime, err := time.Parse(time.RFC3339, powerUp.Start)
+		if err != nil {
+			continue // Skip invalid entries
+		}
+		endTime, err := time.Parse(time.RFC3339, powerUp.End)
+		if err != nil {
+			continue // Skip invalid entries
+		}
+
+		events = append(events, Event{
+			Code:    powerUp.Code,
+			StartAt: startTime.UTC(),
+			EndAt:   endTime.UTC(),
+			Region:  eventRegion,
+		})
+	}
+
+	return events
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPowerUpFeed = `{
+	"data": [
+		{"start": "2025-03-01T12:00:00.000Z", "end": "2025-03-01T14:00:00.000Z", "code": "PU-1", "region": "_C"},
+		{"start": "2025-03-01T12:00:00.000Z", "end": "2025-03-01T14:00:00.000Z", "code": "PU-2", "region": "_H"},
+		{"start": "2025-03-02T10:00:00Z", "end": "2025-03-02T11:00:00Z", "code": "PU-3", "region": "London"},
+		{"start": "2025-03-03T10:00:00Z", "end": "2025-03-03T11:00:00Z", "code": "PU-4"},
+		{"start": "2025-03-04T10:00:00Z", "end": "2025-03-04T11:00:00Z", "code": "PU-5", "region": "Atlantis"}
+	]
+}`
+
+func TestNormalizeRegion(t *testing.T) {
+	tests := map[string]string{
+		"_C":       "_C",
+		"c":        "_C",
+		"London":   "_C",
+		"london":   "_C",
+		" _p ":     "_P",
+		"":         "",
+		"Atlantis": "",
+		"_Z":       "",
+	}
+
+	for input, expected := range tests {
+		if got := normalizeRegion(input); got != expected {
+			t.Errorf("normalizeRegion(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestFetchPowerUps_LocalFile(t *testing.T) {
+	tempDir := t.TempDir()
+	feedFile := filepath.Join(tempDir, "power_ups.json")
+	if err := os.WriteFile(feedFile, []byte(testPowerUpFeed), 0644); err != nil {
+		t.Fatalf("Failed to create feed file: %v", err)
+	}
+
+	config := &Config{PowerUps: PowerUpsConfig{Region: "London", File: feedFile}}
+
+	events, err := fetchPowerUps(context.Background(), config, http.DefaultClient)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	codes := make(map[string]Event)
+	for _, event := range events {
+		codes[event.Code] = event
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 power-ups for London, got %d", len(events))
+	}
+	for _, code := range []string{"PU-1", "PU-3", "PU-4"} {
+		if _, ok := codes[code]; !ok {
+			t.Errorf("Expected power-up %s to be kept", code)
+		}
+	}
+	if codes["PU-1"].Region != "_C" || codes["PU-3"].Region != "_C" {
+		t.Error("Expected regional power-ups to be tagged with their GSP group")
+	}
+	if codes["PU-4"].Region != "" {
+		t.Error("Expected national power-up to have no region")
+	}
+}
+
+func TestFetchPowerUps_NoFeed(t *testing.T) {
+	config := &Config{PowerUps: PowerUpsConfig{Region: "_C"}}
+
+	if _, err := fetchPowerUps(context.Background(), config, http.DefaultClient); err == nil {
+		t.Error("Expected error when no feed is configured, got nil")
+	}
+}
+
+func TestLookupMeterPointRegion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/1000000000000/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"gsp": "_H", "mpan": "1000000000000", "profile_class": 1}`))
+	}))
+	defer server.Close()
+
+	region, err := lookupMeterPointRegion(context.Background(), server.Client(), server.URL, "1000000000000")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if region != "_H" {
+		t.Errorf("Expected region _H, got %q", region)
+	}
+
+	if _, err := lookupMeterPointRegion(context.Background(), server.Client(), server.URL, "9999999999999"); err == nil {
+		t.Error("Expected error for unknown meter point, got nil")
+	}
+}
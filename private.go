@@ -0,0 +1,209 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PrivateEvent records an account's participation in a single event
+type PrivateEvent struct {
+	Start              string `json:"start"`
+	End                string `json:"end"`
+	Code               string `json:"code,omitempty"`
+	Campaign           string `json:"campaign"`
+	OctopusCode        string `json:"octopus_code"`
+	Name               string `json:"name,omitempty"`
+	IsEventParticipant bool   `json:"is_event_participant"`
+	LastSeen           string `json:"last_seen"`
+}
+
+// PrivateAccount holds the participation history of one account
+type PrivateAccount struct {
+	Events []PrivateEvent `json:"events"`
+}
+
+// PrivateOutputData is the private participation output, keyed by account number
+type PrivateOutputData struct {
+	Accounts map[string]*PrivateAccount `json:"accounts"`
+}
+
+// updatePrivateOutput records participation for the account's campaign events
+// in the private output, alongside the public code each event was given
+func updatePrivateOutput(ctx context.Context, config *Config, results []sourceResult) error {
+	var events []Event
+	for _, result := range results {
+		if result.err != nil {
+			continue
+		}
+		for _, event := range result.events {
+			// Only campaign events are specific to this account
+			if event.Campaign != "" {
+				events = append(events, event)
+			}
+		}
+	}
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	publicCodes, err := loadPublicCodes(outputTargets(config))
+	if err != nil {
+		return errors.Wrap(err, "failed to load public codes")
+	}
+
+	output, err := loadPrivateOutput(config.PrivateOutputFile)
+	if err != nil {
+		return errors.Wrap(err, "failed to load private output")
+	}
+
+	mergePrivateEvents(output, config.AccountNumber, events, publicCodes, runClock(config).UTC())
+
+	if err := ctx.Err(); err != nil {
+		return errors.Wrap(err, "run cancelled before saving private output")
+	}
+
+	if err := savePrivateOutput(config.PrivateOutputFile, output); err != nil {
+		return errors.Wrap(err, "failed to save private output")
+	}
+
+	slog.Info("Updated private participation output",
+		"file", config.PrivateOutputFile,
+		"events", len(output.Accounts[config.AccountNumber].Events))
+	return nil
+}
+
+// privateEventKey identifies an event within a campaign by its time window
+func privateEventKey(campaign string, start, end time.Time) string {
+	return campaign + "_" + start.Format(time.RFC3339) + "_" + end.Format(time.RFC3339)
+}
+
+// loadPublicCodes maps each event in the public outputs to its public code
+func loadPublicCodes(targets []CampaignConfig) (map[string]string, error) {
+	codes := make(map[string]string)
+	for _, target := range targets {
+		events, err := loadExistingEvents(target.OutputFile)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			codes[privateEventKey(target.Slug, event.StartAt, event.EndAt)] = event.Code
+		}
+	}
+	return codes, nil
+}
+
+// mergePrivateEvents updates the account's history with the latest events,
+// keeping events that are no longer returned by the API
+func mergePrivateEvents(output *PrivateOutputData, accountNumber string, events []Event, publicCodes map[string]string, now time.Time) {
+	account, ok := output.Accounts[accountNumber]
+	if !ok {
+		account = &PrivateAccount{}
+		output.Accounts[accountNumber] = account
+	}
+
+	index := make(map[string]int, len(account.Events))
+	for i, event := range account.Events {
+		start, startErr := time.Parse("2006-01-02T15:04:05.000Z", event.Start)
+		end, endErr := time.Parse("2006-01-02T15:04:05.000Z", event.End)
+		if startErr != nil || endErr != nil {
+			continue
+		}
+		index[privateEventKey(event.Campaign, start, end)] = i
+	}
+
+	for _, event := range events {
+		key := privateEventKey(event.Campaign, event.StartAt, event.EndAt)
+		participant := event.IsEventParticipant || (event.Joined != nil && *event.Joined)
+
+		record := PrivateEvent{
+			Start:              event.StartAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+			End:                event.EndAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+			Code:               publicCodes[key],
+			Campaign:           event.Campaign,
+			OctopusCode:        event.Code,
+			Name:               event.Name,
+			IsEventParticipant: participant,
+			LastSeen:           now.Format("2006-01-02T15:04:05.000Z"),
+		}
+
+		if i, ok := index[key]; ok {
+			account.Events[i] = record
+		} else {
+			index[key] = len(account.Events)
+			account.Events = append(account.Events, record)
+		}
+	}
+
+	sort.SliceStable(account.Events, func(i, j int) bool {
+		return account.Events[i].Start < account.Events[j].Start
+	})
+}
+
+// loadPrivateOutput reads the private output, returning an empty one if missing
+func loadPrivateOutput(filename string) (*PrivateOutputData, error) {
+	output := &PrivateOutputData{Accounts: make(map[string]*PrivateAccount)}
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return output, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, output); err != nil {
+		return nil, err
+	}
+	if output.Accounts == nil {
+		output.Accounts = make(map[string]*PrivateAccount)
+	}
+
+	return output, nil
+}
+
+// savePrivateOutput writes the private output readable only by its owner
+func savePrivateOutput(filename string, output *PrivateOutputData) error {
+	data, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(filename, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Chmod(filename, 0600)
+}
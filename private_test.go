@@ -0,0 +1,112 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdatePrivateOutput(t *testing.T) {
+	tempDir := t.TempDir()
+	config := &Config{
+		AccountNumber:     "A-12345678",
+		OutputFile:        filepath.Join(tempDir, "free_electricity.json"),
+		PrivateOutputFile: filepath.Join(tempDir, "private.json"),
+	}
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	public := []Event{{Code: "5", StartAt: start, EndAt: start.Add(time.Hour)}}
+	if err := saveEvents(public, config.OutputFile); err != nil {
+		t.Fatalf("Failed to write public output: %v", err)
+	}
+
+	results := []sourceResult{
+		{name: "octopus", events: []Event{
+			{Code: "OE-1", Name: "Free Electricity", Campaign: "free_electricity", StartAt: start, EndAt: start.Add(time.Hour), IsEventParticipant: true},
+		}},
+		{name: "david_kendall", events: []Event{{Code: "DK-1", StartAt: start.Add(24 * time.Hour), EndAt: start.Add(25 * time.Hour)}}},
+	}
+	if err := updatePrivateOutput(context.Background(), config, results); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A later run no longer returns the first event but returns a new one
+	later := []sourceResult{{name: "octopus", events: []Event{
+		{Code: "OE-2", Campaign: "free_electricity", StartAt: start.Add(48 * time.Hour), EndAt: start.Add(49 * time.Hour)},
+	}}}
+	if err := updatePrivateOutput(context.Background(), config, later); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	output, err := loadPrivateOutput(config.PrivateOutputFile)
+	if err != nil {
+		t.Fatalf("Failed to load private output: %v", err)
+	}
+
+	events := output.Accounts["A-12345678"].Events
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 private events, got %d", len(events))
+	}
+	if events[0].OctopusCode != "OE-1" || events[0].Code != "5" || !events[0].IsEventParticipant || events[0].Name != "Free Electricity" {
+		t.Errorf("Unexpected first event %+v", events[0])
+	}
+	if events[1].OctopusCode != "OE-2" || events[1].IsEventParticipant {
+		t.Errorf("Unexpected second event %+v", events[1])
+	}
+
+	info, err := os.Stat(config.PrivateOutputFile)
+	if err != nil {
+		t.Fatalf("Failed to stat private output: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected private output mode 0600, got %o", info.Mode().Perm())
+	}
+
+	// The public output must stay anonymous
+	data, err := os.ReadFile(config.OutputFile)
+	if err != nil {
+		t.Fatalf("Failed to read public output: %v", err)
+	}
+	if strings.Contains(string(data), "OE-1") || strings.Contains(string(data), "participant") {
+		t.Error("Expected public output to contain no participation details")
+	}
+}
+
+func TestMarkJoinedEvents(t *testing.T) {
+	results := []sourceResult{{name: "octopus", events: []Event{
+		{Code: "OE-1", Campaign: "free_electricity"},
+		{Code: "OE-2", Campaign: "free_electricity"},
+	}}}
+	records := []OptInRecord{
+		{Campaign: "free_electricity", EventCode: "OE-1", Outcome: optInJoined},
+		{Campaign: "free_electricity", EventCode: "OE-2", Outcome: optInFailed},
+	}
+
+	markJoinedEvents(results, records)
+
+	if !results[0].events[0].IsEventParticipant {
+		t.Error("Expected joined event to be marked as participating")
+	}
+	if results[0].events[1].IsEventParticipant {
+		t.Error("Expected failed event not to be marked as participating")
+	}
+}
@@ -0,0 +1,348 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+// recordedClockFile holds the time of a recorded run. It is not an exchange,
+// so it is kept out of the *.json files the replay loads
+const recordedClockFile = "clock.txt"
+
+// sensitiveHeaders are replaced in recordings
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// sensitiveFields are JSON keys whose values are replaced in recorded bodies
+var sensitiveFields = map[string]bool{
+	"APIKey":       true,
+	"apiKey":       true,
+	"token":        true,
+	"refreshToken": true,
+}
+
+// recordedExchange is a single HTTP request and response as stored on disk
+type recordedExchange struct {
+	Request struct {
+		Method string      `json:"method"`
+		URL    string      `json:"url"`
+		Header http.Header `json:"header"`
+		Body   string      `json:"body"`
+	} `json:"request"`
+	Response struct {
+		StatusCode int         `json:"statusCode"`
+		Header     http.Header `json:"header"`
+		Body       string      `json:"body"`
+	} `json:"response"`
+}
+
+// key identifies the request an exchange answers, ignoring credentials
+func (e *recordedExchange) key() string {
+	sum := sha256.Sum256([]byte(e.Request.Method + " " + e.Request.URL + "\n" + e.Request.Body))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordingTransport saves every exchange to a directory, redacting credentials
+type recordingTransport struct {
+	base  http.RoundTripper
+	dir   string
+	mutex sync.Mutex
+	count int
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	responseBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(responseBody))
+
+	exchange := newRecordedExchange(req, requestBody)
+	exchange.Response.StatusCode = resp.StatusCode
+	exchange.Response.Header = redactHeader(resp.Header)
+	exchange.Response.Body = string(redactBody(responseBody))
+
+	if err := t.save(exchange); err != nil {
+		return nil, fmt.Errorf("failed to record exchange: %w", err)
+	}
+
+	return resp, nil
+}
+
+// save writes an exchange to the next numbered file in the directory
+func (t *recordingTransport) save(exchange *recordedExchange) error {
+	data, err := json.MarshalIndent(exchange, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err := os.MkdirAll(t.dir, 0700); err != nil {
+		return err
+	}
+	t.count++
+	return os.WriteFile(filepath.Join(t.dir, fmt.Sprintf("%04d.json", t.count)), data, 0600)
+}
+
+// replayTransport answers requests from a recording without using the network.
+// Identical requests are answered in the order they were recorded
+type replayTransport struct {
+	mutex     sync.Mutex
+	exchanges map[string][]*recordedExchange
+}
+
+// newReplayTransport loads every exchange recorded in a directory
+func newReplayTransport(dir string) (*replayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no recorded exchanges found in %s", dir)
+	}
+	sort.Strings(files)
+
+	t := &replayTransport{exchanges: make(map[string][]*recordedExchange)}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var exchange recordedExchange
+		if err := json.Unmarshal(data, &exchange); err != nil {
+			return nil, fmt.Errorf("invalid recording %s: %w", file, err)
+		}
+		key := exchange.key()
+		t.exchanges[key] = append(t.exchanges[key], &exchange)
+	}
+
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	key := newRecordedExchange(req, requestBody).key()
+
+	t.mutex.Lock()
+	queue := t.exchanges[key]
+	if len(queue) == 0 {
+		t.mutex.Unlock()
+		return nil, fmt.Errorf("no recorded response for %s %s", req.Method, req.URL)
+	}
+	exchange := queue[0]
+	// Keep replaying the last response once the recorded ones run out
+	if len(queue) > 1 {
+		t.exchanges[key] = queue[1:]
+	}
+	t.mutex.Unlock()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Response.StatusCode, http.StatusText(exchange.Response.StatusCode)),
+		StatusCode:    exchange.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        exchange.Response.Header.Clone(),
+		Body:          io.NopCloser(strings.NewReader(exchange.Response.Body)),
+		ContentLength: int64(len(exchange.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// newRecordedExchange captures the redacted request half of an exchange
+func newRecordedExchange(req *http.Request, body []byte) *recordedExchange {
+	exchange := &recordedExchange{}
+	exchange.Request.Method = req.Method
+	exchange.Request.URL = req.URL.String()
+	exchange.Request.Header = redactHeader(req.Header)
+	exchange.Request.Body = string(redactBody(body))
+	return exchange
+}
+
+// readRequestBody reads a request body and restores it for sending
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// redactHeader copies a header, replacing credentials
+func redactHeader(header http.Header) http.Header {
+	clone := header.Clone()
+	if clone == nil {
+		clone = http.Header{}
+	}
+	for _, name := range sensitiveHeaders {
+		if clone.Get(name) != "" {
+			clone.Set(name, redacted)
+		}
+	}
+	return clone
+}
+
+// redactBody replaces credentials in a JSON body. The result is re-encoded
+// with sorted keys so equivalent bodies compare equal; other bodies are kept
+func redactBody(body []byte) []byte {
+	var value interface{}
+	if len(body) == 0 || json.Unmarshal(body, &value) != nil {
+		return body
+	}
+
+	redacted, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return body
+	}
+	return redacted
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if sensitiveFields[key] {
+				if _, isString := field.(string); isString {
+					v[key] = redacted
+					continue
+				}
+			}
+			v[key] = redactValue(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
+
+// newRunHTTPClient builds the shared HTTP client for a run, recording or
+// replaying its exchanges when configured. A recording also keeps the time
+// of the run, and a replay pins the run clock to it
+func newRunHTTPClient(config *Config) (*http.Client, error) {
+	client, err := newHTTPClient(config.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case config.ReplayDir != "":
+		transport, err := newReplayTransport(config.ReplayDir)
+		if err != nil {
+			return nil, err
+		}
+		client.Transport = transport
+		if config.Clock, err = loadRecordedClock(config.ReplayDir); err != nil {
+			return nil, err
+		}
+	case config.RecordDir != "":
+		client.Transport = &recordingTransport{base: client.Transport, dir: config.RecordDir}
+		config.Clock = runClock(config).UTC()
+		if err := saveRecordedClock(config.RecordDir, config.Clock); err != nil {
+			return nil, fmt.Errorf("failed to record run clock: %w", err)
+		}
+	}
+
+	return client, nil
+}
+
+// isolateRecordedRun keeps recordings independent of the local caches. A
+// recording bypasses the token cache, so it always holds the token exchange a
+// replay needs. A replay also keeps its state and source health in a
+// throwaway directory, so it neither depends on nor changes the live files.
+// The returned function removes the directory
+func isolateRecordedRun(config *Config) (func(), error) {
+	if config.RecordDir != "" {
+		config.TokenCache = false
+	}
+	if config.ReplayDir == "" {
+		return func() {}, nil
+	}
+
+	dir, err := os.MkdirTemp("", "octoevents-replay-")
+	if err != nil {
+		return nil, err
+	}
+	config.StateFile = filepath.Join(dir, "state.json")
+	config.CircuitBreaker.HealthFile = filepath.Join(dir, "health.json")
+	config.TokenCache = false
+
+	return func() { os.RemoveAll(dir) }, nil
+}
+
+// saveRecordedClock writes the time of a recorded run next to its exchanges
+func saveRecordedClock(dir string, clock time.Time) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, recordedClockFile), []byte(clock.Format(time.RFC3339Nano)), 0600)
+}
+
+// loadRecordedClock reads the time of a recorded run. Recordings made before
+// the clock was kept replay at the current time
+func loadRecordedClock(dir string) (time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(dir, recordedClockFile))
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	clock, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid recorded clock in %s: %w", dir, err)
+	}
+	return clock, nil
+}
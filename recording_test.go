@@ -0,0 +1,210 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactBody(t *testing.T) {
+	body := []byte(`{"variables":{"input":{"APIKey":"sk_live_secret"}},"query":"mutation"}`)
+	got := string(redactBody(body))
+
+	if strings.Contains(got, "sk_live_secret") {
+		t.Errorf("Expected API key to be redacted, got %s", got)
+	}
+	if got != `{"query":"mutation","variables":{"input":{"APIKey":"REDACTED"}}}` {
+		t.Errorf("Unexpected redacted body %s", got)
+	}
+	if got := string(redactBody([]byte("not json"))); got != "not json" {
+		t.Errorf("Expected non-JSON body to be kept, got %s", got)
+	}
+}
+
+func TestRecordingTransport_RedactsAndReplays(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"data":{"obtainKrakenToken":{"token":"secret-token"}}}`))
+	}))
+	dir := t.TempDir()
+
+	recorder := &http.Client{Transport: &recordingTransport{base: http.DefaultTransport, dir: dir}}
+	req, _ := http.NewRequest("POST", server.URL, strings.NewReader(`{"variables":{"input":{"APIKey":"sk_live_one"}}}`))
+	req.Header.Set("Authorization", "secret-token")
+	resp, err := recorder.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	server.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "0001.json"))
+	if err != nil {
+		t.Fatalf("Expected exchange to be recorded: %v", err)
+	}
+	if bytes.Contains(data, []byte("secret-token")) || bytes.Contains(data, []byte("sk_live_one")) {
+		t.Errorf("Expected credentials to be redacted, got %s", data)
+	}
+
+	transport, err := newReplayTransport(dir)
+	if err != nil {
+		t.Fatalf("Failed to load recording: %v", err)
+	}
+	replayer := &http.Client{Transport: transport}
+
+	// A different API key still matches once redacted
+	req, _ = http.NewRequest("POST", server.URL, strings.NewReader(`{"variables":{"input":{"APIKey":"sk_live_two"}}}`))
+	resp, err = replayer.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected replay error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected recorded status, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("GET", server.URL+"/unknown", nil)
+	if _, err := replayer.Do(req); err == nil {
+		t.Error("Expected error for a request that was not recorded")
+	}
+}
+
+func TestReplay_ReproducesRun(t *testing.T) {
+	server := httptest.NewServer(newMockServer(defaultMockFixtures(time.Now())).handler())
+	recording := t.TempDir()
+
+	recordDir := chdirTemp(t)
+	config := mockRunConfig(recordDir, server.URL)
+	config.RecordDir = recording
+	if err := fetchAndUpdateEvents(context.Background(), config); err != nil {
+		t.Fatalf("Unexpected error while recording: %v", err)
+	}
+	server.Close()
+
+	replayDir := chdirTemp(t)
+	config = mockRunConfig(replayDir, server.URL)
+	config.ReplayDir = recording
+	if err := fetchAndUpdateEvents(context.Background(), config); err != nil {
+		t.Fatalf("Unexpected error while replaying: %v", err)
+	}
+
+	recorded, err := os.ReadFile(filepath.Join(recordDir, "free_electricity.json"))
+	if err != nil {
+		t.Fatalf("Failed to read recorded output: %v", err)
+	}
+	replayed, err := os.ReadFile(filepath.Join(replayDir, "free_electricity.json"))
+	if err != nil {
+		t.Fatalf("Failed to read replayed output: %v", err)
+	}
+	if !bytes.Equal(recorded, replayed) {
+		t.Errorf("Expected replayed output to match recorded run\nrecorded: %s\nreplayed: %s", recorded, replayed)
+	}
+}
+
+func TestReplay_IsHermetic(t *testing.T) {
+	server := httptest.NewServer(newMockServer(defaultMockFixtures(time.Now())).handler())
+	recording := t.TempDir()
+
+	config := mockRunConfig(chdirTemp(t), server.URL)
+	config.RecordDir = recording
+	if err := fetchAndUpdateEvents(context.Background(), config); err != nil {
+		t.Fatalf("Unexpected error while recording: %v", err)
+	}
+	server.Close()
+
+	replayDir := chdirTemp(t)
+	config = mockRunConfig(replayDir, server.URL)
+	config.ReplayDir = recording
+	config.TokenCache = true
+	if err := fetchAndUpdateEvents(context.Background(), config); err != nil {
+		t.Fatalf("Unexpected error while replaying: %v", err)
+	}
+
+	// Only the output is written; state, source health and caches are not
+	for _, name := range []string{"state.json", "health.json", cacheDir} {
+		if _, err := os.Stat(filepath.Join(replayDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected replay not to write %s", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(replayDir, "free_electricity.json")); err != nil {
+		t.Errorf("Expected replay to write the output: %v", err)
+	}
+}
+
+func TestReplay_RecordedOnWarmCache(t *testing.T) {
+	server := httptest.NewServer(newMockServer(defaultMockFixtures(time.Now())).handler())
+	recording := t.TempDir()
+
+	// A normal run leaves an ETag and a token in the cache. Its output is
+	// kept apart so the recorded run starts from the same files as the replay
+	recordDir := chdirTemp(t)
+	config := mockRunConfig(t.TempDir(), server.URL)
+	config.TokenCache = true
+	if err := fetchAndUpdateEvents(context.Background(), config); err != nil {
+		t.Fatalf("Unexpected error while warming the cache: %v", err)
+	}
+
+	config = mockRunConfig(recordDir, server.URL)
+	config.TokenCache = true
+	config.RecordDir = recording
+	if err := fetchAndUpdateEvents(context.Background(), config); err != nil {
+		t.Fatalf("Unexpected error while recording: %v", err)
+	}
+	server.Close()
+
+	replayDir := chdirTemp(t)
+	config = mockRunConfig(replayDir, server.URL)
+	config.ReplayDir = recording
+	if err := fetchAndUpdateEvents(context.Background(), config); err != nil {
+		t.Fatalf("Unexpected error while replaying: %v", err)
+	}
+
+	recorded, err := os.ReadFile(filepath.Join(recordDir, "free_electricity.json"))
+	if err != nil {
+		t.Fatalf("Failed to read recorded output: %v", err)
+	}
+	replayed, err := os.ReadFile(filepath.Join(replayDir, "free_electricity.json"))
+	if err != nil {
+		t.Fatalf("Failed to read replayed output: %v", err)
+	}
+	if !bytes.Equal(recorded, replayed) {
+		t.Errorf("Expected replayed output to match recorded run\nrecorded: %s\nreplayed: %s", recorded, replayed)
+	}
+}
+
+func TestReplay_FailsWhenEverySourceFails(t *testing.T) {
+	recording := t.TempDir()
+	// An exchange nothing in the run asks for
+	exchange := `{"request":{"method":"GET","url":"http://example.invalid/"},"response":{"statusCode":200}}`
+	if err := os.WriteFile(filepath.Join(recording, "0001.json"), []byte(exchange), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := mockRunConfig(chdirTemp(t), "http://example.invalid")
+	config.ReplayDir = recording
+	if err := fetchAndUpdateEvents(context.Background(), config); err == nil {
+		t.Error("Expected a replay where every source fails to return an error")
+	}
+}
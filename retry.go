@@ -0,0 +1,162 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Retry defaults, used for any setting left unset in the configuration
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryBaseDelay   = time.Second
+	defaultRetryMaxDelay    = 30 * time.Second
+	defaultRetryJitter      = 0.5
+)
+
+// retryPolicy retries transient failures with exponential backoff and jitter
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	jitter      float64
+}
+
+// newRetryPolicy builds a retry policy from the configuration, applying defaults
+func newRetryPolicy(config RetryConfig) retryPolicy {
+	policy := retryPolicy{
+		maxAttempts: config.MaxAttempts,
+		baseDelay:   config.BaseDelay,
+		maxDelay:    config.MaxDelay,
+		jitter:      defaultRetryJitter,
+	}
+	if policy.maxAttempts <= 0 {
+		policy.maxAttempts = defaultRetryMaxAttempts
+	}
+	if policy.baseDelay <= 0 {
+		policy.baseDelay = defaultRetryBaseDelay
+	}
+	if policy.maxDelay <= 0 {
+		policy.maxDelay = defaultRetryMaxDelay
+	}
+	if config.Jitter != nil {
+		policy.jitter = *config.Jitter
+	}
+	return policy
+}
+
+// backoff returns the delay before the given retry, doubling from the base
+// delay up to the cap with up to jitter of it randomly removed
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < attempt && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+	if p.jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.jitter * float64(delay))
+	}
+	return delay
+}
+
+// do runs fn until it succeeds, fails with an error that is not transient or
+// runs out of attempts. A Retry-After longer than the maximum delay is
+// honoured by giving up rather than retrying early, and nothing is retried
+// once the context, such as the source's own deadline, is done
+func (p retryPolicy) do(ctx context.Context, operation string, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || ctx.Err() != nil || errorClassOf(err) != ErrorClassTransient || attempt >= p.maxAttempts {
+			return err
+		}
+
+		delay := p.backoff(attempt)
+		if retryAfter := retryAfterOf(err); retryAfter > 0 {
+			if retryAfter > p.maxDelay {
+				slog.Warn("Not retrying, server asked to wait longer than the maximum delay",
+					"operation", operation, "retry_after", retryAfter, "max_delay", p.maxDelay)
+				return err
+			}
+			delay = max(delay, retryAfter)
+		}
+
+		slog.Warn("Retrying after transient error",
+			"operation", operation, "attempt", attempt, "max_attempts", p.maxAttempts, "delay", delay, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+// httpStatusError is an unexpected HTTP status from a plain HTTP fetcher
+type httpStatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// newHTTPStatusError records an unexpected status along with any Retry-After
+func newHTTPStatusError(resp *http.Response) *httpStatusError {
+	return &httpStatusError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// retryAfterOf returns how long the server asked us to wait, if it did
+func retryAfterOf(err error) time.Duration {
+	var graphqlErr *GraphQLError
+	if errors.As(err, &graphqlErr) {
+		return graphqlErr.RetryAfter
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.RetryAfter
+	}
+	return 0
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewRetryPolicy_Defaults(t *testing.T) {
+	policy := newRetryPolicy(RetryConfig{})
+	if policy.maxAttempts != defaultRetryMaxAttempts || policy.baseDelay != defaultRetryBaseDelay ||
+		policy.maxDelay != defaultRetryMaxDelay || policy.jitter != defaultRetryJitter {
+		t.Errorf("Expected defaults, got %+v", policy)
+	}
+
+	noJitter := 0.0
+	policy = newRetryPolicy(RetryConfig{MaxAttempts: 5, Jitter: &noJitter})
+	if policy.maxAttempts != 5 || policy.jitter != 0 {
+		t.Errorf("Expected configured values, got %+v", policy)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := retryPolicy{baseDelay: time.Second, maxDelay: 5 * time.Second}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, want := range expected {
+		if got := policy.backoff(i + 1); got != want {
+			t.Errorf("backoff(%d) = %v, expected %v", i+1, got, want)
+		}
+	}
+
+	policy.jitter = 0.5
+	for i := 0; i < 20; i++ {
+		if got := policy.backoff(2); got < time.Second || got > 2*time.Second {
+			t.Fatalf("Expected jittered backoff within [1s, 2s], got %v", got)
+		}
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	policy := retryPolicy{maxAttempts: 3, baseDelay: time.Millisecond, maxDelay: time.Millisecond}
+	transient := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+
+	calls := 0
+	err := policy.do(context.Background(), "test", func() error {
+		calls++
+		if calls < 3 {
+			return transient
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success on third attempt, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	policy.do(context.Background(), "test", func() error {
+		calls++
+		return errors.New("permanent")
+	})
+	if calls != 1 {
+		t.Errorf("Expected permanent error not to be retried, got %d calls", calls)
+	}
+
+	calls = 0
+	policy.do(context.Background(), "test", func() error {
+		calls++
+		return &httpStatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}
+	})
+	if calls != 1 {
+		t.Errorf("Expected Retry-After beyond the maximum delay to stop retrying, got %d calls", calls)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	policy.do(ctx, "test", func() error {
+		calls++
+		cancel()
+		return transient
+	})
+	if calls != 1 {
+		t.Errorf("Expected nothing to be retried once the context is done, got %d calls", calls)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := parseRetryAfter("120", now); got != 2*time.Minute {
+		t.Errorf("Expected 2m from seconds, got %v", got)
+	}
+	if got := parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now); got != 30*time.Second {
+		t.Errorf("Expected 30s from HTTP date, got %v", got)
+	}
+	if got := parseRetryAfter("soon", now); got != 0 {
+		t.Errorf("Expected 0 for invalid value, got %v", got)
+	}
+}
+
+func TestFetchDavidKendallData_RetriesRateLimit(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"data": [{"start": "2025-01-01T12:00:00.000Z", "end": "2025-01-01T13:00:00.000Z", "code": "1"}]}`))
+	}))
+	defer server.Close()
+
+	policy := retryPolicy{maxAttempts: 2, baseDelay: time.Millisecond, maxDelay: 2 * time.Second}
+	start := time.Now()
+	events, err := fetchDavidKendallData(context.Background(), server.Client(), server.URL, policy, t.TempDir())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(events) != 1 || atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("Expected one event after a retry, got %d events from %d requests", len(events), requests)
+	}
+	if time.Since(start) < time.Second {
+		t.Error("Expected Retry-After to be honoured")
+	}
+}
@@ -0,0 +1,437 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/machinebox/graphql"
+	"github.com/pkg/errors"
+)
+
+// expectedSchemaJSON is the part of the Kraken schema our queries depend on,
+// flattened to "Type.field" and "Type.field(arg)" keys mapped to type refs.
+// It is generated with schema-check -update
+//
+//go:embed kraken-schema.json
+var expectedSchemaJSON []byte
+
+// Root type keys, used whatever the live root types are called
+const (
+	schemaRootQuery    = "Query"
+	schemaRootMutation = "Mutation"
+)
+
+// schemaSelection is the part of a type our queries select: each field maps
+// to the selection of its own type, or to nil for a scalar
+type schemaSelection map[string]schemaSelection
+
+// schemaWatched mirrors the fields our queries and mutations select, from the
+// root types down. The arguments of every watched field are checked too
+var schemaWatched = map[string]schemaSelection{
+	schemaRootQuery: {
+		"customerFlexibilityCampaignEvents": {
+			"edges": {
+				"cursor": nil,
+				"node": {
+					"code":               nil,
+					"endAt":              nil,
+					"isEventParticipant": nil,
+					"name":               nil,
+					"startAt":            nil,
+				},
+			},
+			"pageInfo": {
+				"endCursor":       nil,
+				"hasNextPage":     nil,
+				"hasPreviousPage": nil,
+				"startCursor":     nil,
+			},
+			"totalCount": nil,
+			"edgeCount":  nil,
+		},
+		"isEnrolledInCustomerFlexibilityCampaign": nil,
+		"savingSessions": {
+			"events": {
+				"id":                       nil,
+				"code":                     nil,
+				"startAt":                  nil,
+				"endAt":                    nil,
+				"rewardPerKwhInOctoPoints": nil,
+			},
+			"account": {
+				"hasJoinedCampaign": nil,
+				"joinedEvents": {
+					"eventId":                 nil,
+					"startAt":                 nil,
+					"endAt":                   nil,
+					"rewardGivenInOctoPoints": nil,
+				},
+			},
+		},
+	},
+	schemaRootMutation: {
+		"obtainKrakenToken": {
+			"token":            nil,
+			"refreshToken":     nil,
+			"refreshExpiresIn": nil,
+		},
+		"joinCustomerFlexibilityCampaignEvent": {
+			"isEventParticipant": nil,
+		},
+	},
+}
+
+const schemaTypeRefFragments = `
+	fragment TypeFields on __Type {
+		name
+		fields(includeDeprecated: true) { ...Field }
+	}
+	fragment Field on __Field {
+		name
+		args { name type { ...TypeRef } }
+		type { ...TypeRef }
+	}
+	fragment TypeRef on __Type {
+		kind
+		name
+		ofType { kind name ofType { kind name ofType { kind name } } }
+	}
+`
+
+// introspectionTypeRef is a possibly wrapped reference to a named type
+type introspectionTypeRef struct {
+	Kind   string                `json:"kind"`
+	Name   string                `json:"name,omitempty"`
+	OfType *introspectionTypeRef `json:"ofType"`
+}
+
+// String renders a type ref in SDL notation, such as [String!]!
+func (r *introspectionTypeRef) String() string {
+	if r == nil {
+		return ""
+	}
+	switch r.Kind {
+	case "NON_NULL":
+		return r.OfType.String() + "!"
+	case "LIST":
+		return "[" + r.OfType.String() + "]"
+	}
+	return r.Name
+}
+
+// named returns the innermost named type
+func (r *introspectionTypeRef) named() string {
+	for r != nil && r.OfType != nil {
+		r = r.OfType
+	}
+	if r == nil {
+		return ""
+	}
+	return r.Name
+}
+
+// introspectionInputValue is an argument of a field
+type introspectionInputValue struct {
+	Name string               `json:"name"`
+	Type introspectionTypeRef `json:"type"`
+}
+
+// introspectionField is a field of an object type
+type introspectionField struct {
+	Name string                    `json:"name"`
+	Args []introspectionInputValue `json:"args"`
+	Type introspectionTypeRef      `json:"type"`
+}
+
+// introspectionType is an object type and its fields
+type introspectionType struct {
+	Name   string               `json:"name"`
+	Fields []introspectionField `json:"fields"`
+}
+
+// schemaChange is a single difference between the expected and live schema
+type schemaChange struct {
+	Kind     string // added, removed or changed
+	Key      string
+	Expected string
+	Actual   string
+	Breaking bool
+}
+
+func (c schemaChange) String() string {
+	var s string
+	switch c.Kind {
+	case "added":
+		s = fmt.Sprintf("added    %s: %s", c.Key, c.Actual)
+	case "removed":
+		s = fmt.Sprintf("removed  %s (was %s)", c.Key, c.Expected)
+	default:
+		s = fmt.Sprintf("changed  %s: %s -> %s", c.Key, c.Expected, c.Actual)
+	}
+	if c.Breaking {
+		s += " [breaking]"
+	}
+	return s
+}
+
+// runSchemaCheck implements the schema-check command
+func runSchemaCheck(args []string) error {
+	flags := flag.NewFlagSet("schema-check", flag.ExitOnError)
+	configPath := flags.String("config", "", "Path to configuration file")
+	key := flags.String("key", "", "Octopus Energy API Key")
+	brandName := flags.String("brand", "", "Kraken brand preset")
+	endpoint := flags.String("graphql-url", "", "Override the Kraken GraphQL endpoint")
+	update := flags.Bool("update", false, "Print the live schema in the expectation format instead of comparing")
+	timeout := flags.Duration("timeout", time.Minute, "Deadline for the check")
+	flags.Parse(args)
+
+	config := &Config{}
+	if *configPath != "" {
+		if err := loadConfigFromFile(*configPath, config); err != nil {
+			return fmt.Errorf("failed to load config file: %w", err)
+		}
+	}
+	if *key != "" {
+		config.APIKey = *key
+	} else if config.APIKey == "" {
+		config.APIKey = os.Getenv("OCTOPUS_API_KEY")
+	}
+	if config.APIKey == "" {
+		return fmt.Errorf("API key is required (use -key flag, config file, or OCTOPUS_API_KEY env var)")
+	}
+	if *brandName != "" {
+		config.Brand = *brandName
+	}
+	if *endpoint != "" {
+		config.Endpoints.GraphQL = *endpoint
+	}
+	if err := applyBrand(config); err != nil {
+		return err
+	}
+
+	httpClient, err := newHTTPClient(config.HTTP)
+	if err != nil {
+		return errors.Wrap(err, "failed to configure HTTP client")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	actual, err := fetchSchemaSnapshot(ctx, newOctopusClient(config, httpClient))
+	if err != nil {
+		return errors.Wrap(err, "failed to introspect schema")
+	}
+
+	if *update {
+		data, err := json.MarshalIndent(actual, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	var expected map[string]string
+	if err := json.Unmarshal(expectedSchemaJSON, &expected); err != nil {
+		return errors.Wrap(err, "failed to parse embedded schema expectation")
+	}
+
+	changes := diffSchema(expected, actual)
+	breaking := 0
+	for _, change := range changes {
+		fmt.Println(change)
+		if change.Breaking {
+			breaking++
+		}
+	}
+
+	if breaking > 0 {
+		return fmt.Errorf("breaking schema drift: %d of %d changes", breaking, len(changes))
+	}
+	if len(changes) == 0 {
+		fmt.Println("Schema matches expectation")
+	}
+	return nil
+}
+
+// buildSchemaQuery builds an introspection query for the root types, or for
+// the named types using aliases type0, type1 and so on
+func buildSchemaQuery(types []string) string {
+	var b strings.Builder
+	b.WriteString("\n\tquery schemaCheck {\n")
+	if len(types) == 0 {
+		b.WriteString("\t\t__schema { queryType { ...TypeFields } mutationType { ...TypeFields } }\n")
+	}
+	for i, name := range types {
+		fmt.Fprintf(&b, "\t\ttype%d: __type(name: %q) { ...TypeFields }\n", i, name)
+	}
+	b.WriteString("\t}\n")
+	b.WriteString(schemaTypeRefFragments)
+	return b.String()
+}
+
+// fetchSchemaSnapshot introspects the watched fields, starting from the root
+// types and following the types of watched fields one level per request
+func fetchSchemaSnapshot(ctx context.Context, runner graphqlRunner) (map[string]string, error) {
+	var response map[string]json.RawMessage
+	if err := runner.Run(ctx, graphql.NewRequest(buildSchemaQuery(nil)), &response); err != nil {
+		return nil, err
+	}
+
+	var schema struct {
+		QueryType    *introspectionType `json:"queryType"`
+		MutationType *introspectionType `json:"mutationType"`
+	}
+	if err := json.Unmarshal(response["__schema"], &schema); err != nil {
+		return nil, errors.Wrap(err, "failed to decode root types")
+	}
+
+	snapshot := make(map[string]string)
+	pending := snapshotFields(snapshot, schemaRootQuery, schema.QueryType, schemaWatched[schemaRootQuery])
+	for name, selection := range snapshotFields(snapshot, schemaRootMutation, schema.MutationType, schemaWatched[schemaRootMutation]) {
+		pending[name] = mergeSchemaSelections(pending[name], selection)
+	}
+
+	seen := make(map[string]bool)
+	for len(pending) > 0 {
+		names := make([]string, 0, len(pending))
+		for name := range pending {
+			if !seen[name] {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			break
+		}
+		sort.Strings(names)
+
+		response = nil
+		if err := runner.Run(ctx, graphql.NewRequest(buildSchemaQuery(names)), &response); err != nil {
+			return nil, err
+		}
+
+		next := make(map[string]schemaSelection)
+		for i, name := range names {
+			seen[name] = true
+			var t *introspectionType
+			if err := json.Unmarshal(response[fmt.Sprintf("type%d", i)], &t); err != nil {
+				return nil, errors.Wrapf(err, "failed to decode type %s", name)
+			}
+			for child, selection := range snapshotFields(snapshot, name, t, pending[name]) {
+				next[child] = mergeSchemaSelections(next[child], selection)
+			}
+		}
+		pending = next
+	}
+
+	return snapshot, nil
+}
+
+// snapshotFields adds the selected fields of a type, and their arguments, to
+// the snapshot under the given type name. It returns the selections to
+// introspect next, keyed by the name of each field's type
+func snapshotFields(snapshot map[string]string, name string, t *introspectionType, selection schemaSelection) map[string]schemaSelection {
+	next := make(map[string]schemaSelection)
+	if t == nil {
+		return next
+	}
+
+	for _, field := range t.Fields {
+		fields, watched := selection[field.Name]
+		if !watched {
+			continue
+		}
+
+		key := name + "." + field.Name
+		snapshot[key] = field.Type.String()
+		for _, arg := range field.Args {
+			snapshot[key+"("+arg.Name+")"] = arg.Type.String()
+		}
+		if fields != nil {
+			named := field.Type.named()
+			next[named] = mergeSchemaSelections(next[named], fields)
+		}
+	}
+	return next
+}
+
+// mergeSchemaSelections combines two selections of the same type
+func mergeSchemaSelections(a, b schemaSelection) schemaSelection {
+	if a == nil {
+		return b
+	}
+	merged := make(schemaSelection, len(a)+len(b))
+	for field, selection := range a {
+		merged[field] = selection
+	}
+	for field, selection := range b {
+		if existing, ok := merged[field]; ok && existing != nil {
+			merged[field] = mergeSchemaSelections(existing, selection)
+			continue
+		}
+		merged[field] = selection
+	}
+	return merged
+}
+
+// diffSchema reports how the live schema differs from the expectation.
+// Removals and changes break our queries, except that output fields may
+// become non-null and arguments may become optional. Added fields are safe,
+// but a new required argument is not
+func diffSchema(expected, actual map[string]string) []schemaChange {
+	var changes []schemaChange
+
+	for key, want := range expected {
+		got, ok := actual[key]
+		switch {
+		case !ok:
+			changes = append(changes, schemaChange{Kind: "removed", Key: key, Expected: want, Breaking: true})
+		case got != want:
+			compatible := got == want+"!"
+			if isSchemaArgument(key) {
+				compatible = want == got+"!"
+			}
+			changes = append(changes, schemaChange{Kind: "changed", Key: key, Expected: want, Actual: got, Breaking: !compatible})
+		}
+	}
+
+	for key, got := range actual {
+		if _, ok := expected[key]; !ok {
+			breaking := isSchemaArgument(key) && strings.HasSuffix(got, "!")
+			changes = append(changes, schemaChange{Kind: "added", Key: key, Actual: got, Breaking: breaking})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+// isSchemaArgument reports whether a snapshot key is a field argument
+func isSchemaArgument(key string) bool {
+	return strings.HasSuffix(key, ")")
+}
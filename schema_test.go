@@ -0,0 +1,104 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIntrospectionTypeRef_String(t *testing.T) {
+	ref := &introspectionTypeRef{Kind: "NON_NULL", OfType: &introspectionTypeRef{
+		Kind: "LIST", OfType: &introspectionTypeRef{
+			Kind: "NON_NULL", OfType: &introspectionTypeRef{Kind: "SCALAR", Name: "String"},
+		},
+	}}
+
+	if got := ref.String(); got != "[String!]!" {
+		t.Errorf("Expected [String!]!, got %s", got)
+	}
+	if got := ref.named(); got != "String" {
+		t.Errorf("Expected named type String, got %s", got)
+	}
+}
+
+func TestDiffSchema(t *testing.T) {
+	expected := map[string]string{
+		"PageInfo.endCursor":                "String",
+		"PageInfo.hasNextPage":              "Boolean!",
+		"PageInfo.startCursor":              "String",
+		"Mutation.obtainKrakenToken(input)": "ObtainJSONWebTokenInput!",
+		"Event.startAt":                     "DateTime!",
+	}
+	actual := map[string]string{
+		"PageInfo.endCursor":                "String!",
+		"PageInfo.hasNextPage":              "Boolean",
+		"PageInfo.totalCount":               "Int",
+		"Mutation.obtainKrakenToken(input)": "ObtainJSONWebTokenInput",
+		"Mutation.obtainKrakenToken(scope)": "String!",
+		"Event.startAt":                     "DateTime!",
+	}
+
+	want := map[string]struct {
+		kind     string
+		breaking bool
+	}{
+		"PageInfo.endCursor":                {"changed", false},
+		"PageInfo.hasNextPage":              {"changed", true},
+		"PageInfo.startCursor":              {"removed", true},
+		"PageInfo.totalCount":               {"added", false},
+		"Mutation.obtainKrakenToken(input)": {"changed", false},
+		"Mutation.obtainKrakenToken(scope)": {"added", true},
+	}
+
+	changes := diffSchema(expected, actual)
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %v", len(want), changes)
+	}
+	for _, change := range changes {
+		w, ok := want[change.Key]
+		if !ok {
+			t.Errorf("Unexpected change %s", change)
+			continue
+		}
+		if change.Kind != w.kind || change.Breaking != w.breaking {
+			t.Errorf("Expected %s to be %s (breaking %v), got %s", change.Key, w.kind, w.breaking, change)
+		}
+	}
+}
+
+func TestFetchSchemaSnapshot_MockServer(t *testing.T) {
+	server := httptest.NewServer(newMockServer(defaultMockFixtures(time.Now())).handler())
+	defer server.Close()
+
+	client := NewAuthenticatedClient("test-api-key", server.URL+"/graphql")
+	actual, err := fetchSchemaSnapshot(context.Background(), client)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var expected map[string]string
+	if err := json.Unmarshal(expectedSchemaJSON, &expected); err != nil {
+		t.Fatalf("Failed to parse embedded expectation: %v", err)
+	}
+	if changes := diffSchema(expected, actual); len(changes) != 0 {
+		t.Errorf("Expected no drift against the mock server, got %v", changes)
+	}
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const defaultMaxConcurrentFetches = 4
+
+// EventSource is a feed of events that can be merged into the output file
+type EventSource interface {
+	// Name identifies the source in configuration and logs
+	Name() string
+	// Priority orders merging; higher priority sources are merged last and win conflicts
+	Priority() int
+	// Fetch retrieves the current set of events from the source
+	Fetch(ctx context.Context) ([]Event, error)
+}
+
+// enrollmentReporter is implemented by sources that learn whether the meter
+// point is enrolled in each campaign while fetching
+type enrollmentReporter interface {
+	Enrollment() map[string]bool
+}
+
+// campaignErrorReporter is implemented by sources that fetch several campaigns
+// at once and can fail for some of them while the others succeed
+type campaignErrorReporter interface {
+	CampaignErrors() map[string]error
+}
+
+// sourceDefinition describes a registered source and its defaults
+type sourceDefinition struct {
+	name     string
+	priority int
+	timeout  time.Duration
+	enabled  bool
+	// stableCodes marks sources whose event codes survive a reschedule
+	stableCodes bool
+	// authoritative marks sources whose missing events have been cancelled
+	authoritative bool
+	// publicCodes marks sources whose event codes may be published. Other
+	// codes are specific to the account and kept in the state file
+	publicCodes bool
+	factory     func(config *Config, clients sourceClients, priority int) EventSource
+}
+
+// sourceClients are the clients shared by the sources of a run. The Kraken
+// sources use one client between them, so a run exchanges its API key for a
+// token once and only one writer touches the token cache
+type sourceClients struct {
+	http   *http.Client
+	kraken *AuthenticatedClient
+}
+
+// newSourceClients creates the clients for a run on top of its HTTP client
+func newSourceClients(config *Config, httpClient *http.Client) sourceClients {
+	return sourceClients{http: httpClient, kraken: newOctopusClient(config, httpClient)}
+}
+
+// scheduledSource is a configured source ready to be fetched
+type scheduledSource struct {
+	source  EventSource
+	timeout time.Duration
+}
+
+// sourceResult holds the outcome of fetching a single source
+type sourceResult struct {
+	name       string
+	priority   int
+	events     []Event
+	enrollment map[string]bool
+	// campaignErrs holds the campaigns the source failed to fetch, by slug,
+	// when it succeeded for others
+	campaignErrs map[string]error
+	err          error
+}
+
+var sourceRegistry = make(map[string]sourceDefinition)
+
+func init() {
+	registerSource(sourceDefinition{
+		name:        "david_kendall",
+		priority:    50,
+		timeout:     15 * time.Second,
+		enabled:     true,
+		publicCodes: true,
+		factory:     newDavidKendallSource,
+	})
+	registerSource(sourceDefinition{
+		name:          "octopus",
+		priority:      100,
+		timeout:       30 * time.Second,
+		enabled:       true,
+		stableCodes:   true,
+		authoritative: true,
+		factory:       newOctopusSource,
+	})
+	registerSource(sourceDefinition{
+		name:          "saving_sessions",
+		priority:      100,
+		timeout:       30 * time.Second,
+		enabled:       false,
+		stableCodes:   true,
+		authoritative: true,
+		factory:       newSavingSessionsSource,
+	})
+	registerSource(sourceDefinition{
+		name:        "power_ups",
+		priority:    75,
+		timeout:     15 * time.Second,
+		enabled:     false,
+		publicCodes: true,
+		factory:     newPowerUpsSource,
+	})
+}
+
+// registerSource adds a source definition to the registry
+func registerSource(def sourceDefinition) {
+	if _, exists := sourceRegistry[def.name]; exists {
+		panic(fmt.Sprintf("event source %q registered twice", def.name))
+	}
+	sourceRegistry[def.name] = def
+}
+
+// buildSources creates the enabled sources, applying per-source overrides from
+// config. Every source makes its requests with the given HTTP client
+func buildSources(config *Config, clients sourceClients) ([]scheduledSource, error) {
+	for name := range config.Sources {
+		if _, ok := sourceRegistry[name]; !ok {
+			return nil, fmt.Errorf("unknown event source %q in configuration", name)
+		}
+	}
+
+	names := make([]string, 0, len(sourceRegistry))
+	for name := range sourceRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sources := make([]scheduledSource, 0, len(names))
+	for _, name := range names {
+		if !sourceEnabled(config, name) {
+			continue
+		}
+
+		def := sourceRegistry[name]
+		settings := config.Sources[name]
+
+		priority := def.priority
+		if settings.Priority != nil {
+			priority = *settings.Priority
+		}
+
+		timeout := def.timeout
+		if settings.Timeout > 0 {
+			timeout = settings.Timeout
+		}
+
+		sources = append(sources, scheduledSource{
+			source:  def.factory(config, clients, priority),
+			timeout: timeout,
+		})
+	}
+
+	return sources, nil
+}
+
+// sourceEnabled reports whether a registered source is enabled, taking
+// configuration overrides into account
+func sourceEnabled(config *Config, name string) bool {
+	def, ok := sourceRegistry[name]
+	if !ok {
+		return false
+	}
+	if settings, ok := config.Sources[name]; ok && settings.Enabled != nil {
+		return *settings.Enabled
+	}
+	return def.enabled
+}
+
+// fetchSources fetches all sources using a bounded worker pool and returns
+// the results ordered by ascending priority, ready to be merged in turn
+func fetchSources(ctx context.Context, sources []scheduledSource, maxWorkers int) []sourceResult {
+	if maxWorkers <= 0 {
+		maxWorkers = defaultMaxConcurrentFetches
+	}
+	if maxWorkers > len(sources) {
+		maxWorkers = len(sources)
+	}
+
+	results := make([]sourceResult, len(sources))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < maxWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				scheduled := sources[i]
+				events, err := fetchWithTimeout(ctx, scheduled.source, scheduled.timeout)
+				results[i] = sourceResult{
+					name:     scheduled.source.Name(),
+					priority: scheduled.source.Priority(),
+					events:   events,
+					err:      err,
+				}
+				if reporter, ok := scheduled.source.(enrollmentReporter); ok && err == nil {
+					results[i].enrollment = reporter.Enrollment()
+				}
+				if reporter, ok := scheduled.source.(campaignErrorReporter); ok && err == nil {
+					results[i].campaignErrs = reporter.CampaignErrors()
+				}
+			}
+		}()
+	}
+
+	for i := range sources {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].priority != results[j].priority {
+			return results[i].priority < results[j].priority
+		}
+		return results[i].name < results[j].name
+	})
+
+	return results
+}
+
+// fetchWithTimeout runs a single fetch, abandoning it if the timeout elapses
+// even when the source does not honour context cancellation itself
+func fetchWithTimeout(ctx context.Context, source EventSource, timeout time.Duration) ([]Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	type fetchResult struct {
+		events []Event
+		err    error
+	}
+
+	done := make(chan fetchResult, 1)
+	go func() {
+		events, err := source.Fetch(ctx)
+		done <- fetchResult{events: events, err: err}
+	}()
+
+	select {
+	case result := <-done:
+		return result.events, result.err
+	case <-ctx.Done():
+		return nil, errors.Wrapf(ctx.Err(), "source %s did not respond within %s", source.Name(), timeout)
+	}
+}
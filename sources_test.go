@@ -0,0 +1,212 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSource is an EventSource used to exercise the fetch pipeline
+type fakeSource struct {
+	name     string
+	priority int
+	events   []Event
+	err      error
+	delay    time.Duration
+	running  *int32
+	peak     *int32
+}
+
+func (s *fakeSource) Name() string { return s.name }
+
+func (s *fakeSource) Priority() int { return s.priority }
+
+func (s *fakeSource) Fetch(ctx context.Context) ([]Event, error) {
+	if s.running != nil {
+		current := atomic.AddInt32(s.running, 1)
+		defer atomic.AddInt32(s.running, -1)
+		for {
+			peak := atomic.LoadInt32(s.peak)
+			if current <= peak || atomic.CompareAndSwapInt32(s.peak, peak, current) {
+				break
+			}
+		}
+	}
+
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return s.events, s.err
+}
+
+func TestBuildSources_Defaults(t *testing.T) {
+	sources, err := buildSources(&Config{}, sourceClients{http: http.DefaultClient})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	names := make(map[string]bool)
+	for _, scheduled := range sources {
+		names[scheduled.source.Name()] = true
+	}
+
+	for _, expected := range []string{"octopus", "david_kendall"} {
+		if !names[expected] {
+			t.Errorf("Expected source %q to be enabled by default", expected)
+		}
+	}
+}
+
+func TestBuildSources_Overrides(t *testing.T) {
+	disabled := false
+	priority := 5
+	config := &Config{
+		Sources: map[string]SourceConfig{
+			"david_kendall": {Enabled: &disabled},
+			"octopus":       {Priority: &priority, Timeout: 3 * time.Second},
+		},
+	}
+
+	sources, err := buildSources(config, sourceClients{http: http.DefaultClient})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(sources) != 1 {
+		t.Fatalf("Expected 1 source, got %d", len(sources))
+	}
+
+	if sources[0].source.Name() != "octopus" {
+		t.Errorf("Expected octopus source, got %q", sources[0].source.Name())
+	}
+	if sources[0].source.Priority() != 5 {
+		t.Errorf("Expected priority 5, got %d", sources[0].source.Priority())
+	}
+	if sources[0].timeout != 3*time.Second {
+		t.Errorf("Expected timeout 3s, got %s", sources[0].timeout)
+	}
+}
+
+func TestBuildSources_SharesKrakenToken(t *testing.T) {
+	fake := &tokenTestServer{tokenExpiry: time.Hour}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	disabled, enabled := false, true
+	config := testFetchConfig()
+	config.Endpoints.GraphQL = server.URL
+	config.Sources = map[string]SourceConfig{
+		"david_kendall":   {Enabled: &disabled},
+		"saving_sessions": {Enabled: &enabled},
+	}
+
+	sources, err := buildSources(config, newSourceClients(config, server.Client()))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("Expected both Kraken sources, got %d", len(sources))
+	}
+
+	// Both sources run at once, but only one exchanges the API key
+	fetchSources(context.Background(), sources, 2)
+
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.apiKeyCalls != 1 {
+		t.Errorf("Expected one token exchange between the Kraken sources, got %d", fake.apiKeyCalls)
+	}
+}
+
+func TestBuildSources_UnknownSource(t *testing.T) {
+	config := &Config{
+		Sources: map[string]SourceConfig{"nonexistent": {}},
+	}
+
+	if _, err := buildSources(config, sourceClients{http: http.DefaultClient}); err == nil {
+		t.Error("Expected error for unknown source, got nil")
+	}
+}
+
+func TestFetchSources_OrderedByPriority(t *testing.T) {
+	sources := []scheduledSource{
+		{source: &fakeSource{name: "high", priority: 100}, timeout: time.Second},
+		{source: &fakeSource{name: "low", priority: 1, err: errors.New("boom")}, timeout: time.Second},
+		{source: &fakeSource{name: "mid", priority: 50}, timeout: time.Second},
+	}
+
+	results := fetchSources(context.Background(), sources, 2)
+
+	expected := []string{"low", "mid", "high"}
+	for i, name := range expected {
+		if results[i].name != name {
+			t.Errorf("Expected result %d to be %q, got %q", i, name, results[i].name)
+		}
+	}
+
+	if results[0].err == nil {
+		t.Error("Expected error from failing source to be preserved")
+	}
+}
+
+func TestFetchSources_Timeout(t *testing.T) {
+	sources := []scheduledSource{
+		{source: &fakeSource{name: "slow", delay: time.Minute}, timeout: 20 * time.Millisecond},
+	}
+
+	start := time.Now()
+	results := fetchSources(context.Background(), sources, 1)
+
+	if results[0].err == nil {
+		t.Error("Expected timeout error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Timeout was not enforced, took %s", elapsed)
+	}
+}
+
+func TestFetchSources_BoundedWorkers(t *testing.T) {
+	var running, peak int32
+
+	sources := make([]scheduledSource, 6)
+	for i := range sources {
+		sources[i] = scheduledSource{
+			source: &fakeSource{
+				name:    string(rune('a' + i)),
+				delay:   20 * time.Millisecond,
+				running: &running,
+				peak:    &peak,
+			},
+			timeout: time.Second,
+		}
+	}
+
+	fetchSources(context.Background(), sources, 2)
+
+	if peak > 2 {
+		t.Errorf("Expected at most 2 concurrent fetches, observed %d", peak)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// maxStateRecords bounds each history list kept in the state file
+const maxStateRecords = 500
+
+// State is private, per-account information persisted between runs. It is
+// kept separate from the public output and written with owner-only permissions
+type State struct {
+	OptIns     []OptInRecord      `json:"optIns,omitempty"`
+	Enrollment []EnrollmentRecord `json:"enrollment,omitempty"`
+	// Missing counts the consecutive runs each future event has been missing
+	// from its authoritative source, keyed by campaign and window
+	Missing map[string]int `json:"missing,omitempty"`
+	// Codes keeps the upstream codes that are not published in the output,
+	// keyed by campaign, window and source
+	Codes map[string]string `json:"codes,omitempty"`
+}
+
+// stateFilePath returns the configured state file, defaulting to the cache directory
+func stateFilePath(config *Config) string {
+	if config.StateFile != "" {
+		return config.StateFile
+	}
+	return filepath.Join(cacheDir, "state.json")
+}
+
+// loadState reads the state file, returning empty state if it does not exist
+func loadState(filename string) (*State, error) {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+// saveState writes the state file, trimming histories to their newest records
+func saveState(filename string, state *State) error {
+	if len(state.OptIns) > maxStateRecords {
+		state.OptIns = state.OptIns[len(state.OptIns)-maxStateRecords:]
+	}
+	if len(state.Enrollment) > maxStateRecords {
+		state.Enrollment = state.Enrollment[len(state.Enrollment)-maxStateRecords:]
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+
+	// Replace the file in one step so an interrupted run never truncates it
+	return writeFileAtomic(filename, data, 0600)
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	tempDir := t.TempDir()
+	stateFile := filepath.Join(tempDir, "private", "state.json")
+
+	state := &State{
+		OptIns: []OptInRecord{{Campaign: "free_electricity", EventCode: "e1", Outcome: optInJoined}},
+	}
+
+	if err := saveState(stateFile, state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	info, err := os.Stat(stateFile)
+	if err != nil {
+		t.Fatalf("State file was not created: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected state file mode 0600, got %o", info.Mode().Perm())
+	}
+
+	loaded, err := loadState(stateFile)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if len(loaded.OptIns) != 1 || loaded.OptIns[0].EventCode != "e1" {
+		t.Errorf("Unexpected loaded state %+v", loaded)
+	}
+}
+
+func TestSaveState_ReplacesFile(t *testing.T) {
+	dir := t.TempDir()
+	stateFile := filepath.Join(dir, "state.json")
+	if err := os.WriteFile(stateFile, []byte("truncated"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := saveState(stateFile, &State{Missing: map[string]int{"free_electricity/window": 1}}); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	info, err := os.Stat(stateFile)
+	if err != nil {
+		t.Fatalf("State file was not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected state file mode 0600, got %o", info.Mode().Perm())
+	}
+	if loaded, err := loadState(stateFile); err != nil || loaded.Missing["free_electricity/window"] != 1 {
+		t.Errorf("Expected the saved state to load, got %+v and %v", loaded, err)
+	}
+
+	// Nothing but the state file is left behind
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected only the state file, got %d entries", len(entries))
+	}
+}
+
+func TestLoadState_Missing(t *testing.T) {
+	state, err := loadState(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("Unexpected error for missing state: %v", err)
+	}
+	if len(state.OptIns) != 0 {
+		t.Errorf("Expected empty state, got %+v", state)
+	}
+}
+
+func TestSaveState_TrimsHistory(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	state := &State{OptIns: make([]OptInRecord, maxStateRecords+10)}
+	state.OptIns[len(state.OptIns)-1].EventCode = "newest"
+
+	if err := saveState(stateFile, state); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	loaded, err := loadState(stateFile)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if len(loaded.OptIns) != maxStateRecords {
+		t.Errorf("Expected %d records, got %d", maxStateRecords, len(loaded.OptIns))
+	}
+	if loaded.OptIns[len(loaded.OptIns)-1].EventCode != "newest" {
+		t.Error("Expected newest record to be kept")
+	}
+}
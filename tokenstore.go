@@ -0,0 +1,121 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// storedToken is the token state persisted between runs
+type storedToken struct {
+	Token         string    `json:"token"`
+	TokenExpiry   time.Time `json:"tokenExpiry"`
+	RefreshToken  string    `json:"refreshToken"`
+	RefreshExpiry time.Time `json:"refreshExpiry"`
+}
+
+// tokenStore keeps Kraken tokens on disk, encrypted with a key derived from
+// the API key. Anyone able to decrypt a token already holds the API key, which
+// grants more access than the token itself
+type tokenStore struct {
+	dir string
+}
+
+func newTokenStore(dir string) *tokenStore {
+	return &tokenStore{dir: dir}
+}
+
+// path returns the token file for an API key, named by a hash of the key
+func (s *tokenStore) path(apiKey string) string {
+	id := sha256.Sum256([]byte("octoevents:token-id:" + apiKey))
+	return filepath.Join(s.dir, "token-"+hex.EncodeToString(id[:16])+".enc")
+}
+
+// cipher returns the AES-GCM cipher used for an API key's tokens
+func (s *tokenStore) cipher(apiKey string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte("octoevents:token-key:" + apiKey))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// load reads the stored token for an API key, returning nil if there is none
+func (s *tokenStore) load(apiKey string) (*storedToken, error) {
+	data, err := os.ReadFile(s.path(apiKey))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := s.cipher(apiKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("token file is truncated")
+	}
+
+	plaintext, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token file: %w", err)
+	}
+
+	var token storedToken
+	if err := json.Unmarshal(plaintext, &token); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// save encrypts and writes the token for an API key, readable only by its owner
+func (s *tokenStore) save(apiKey string, token *storedToken) error {
+	plaintext, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := s.cipher(apiKey)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return err
+	}
+
+	// Replace the file in one step so a reader never sees a partial token
+	return writeFileAtomic(s.path(apiKey), gcm.Seal(nonce, nonce, plaintext, nil), 0600)
+}
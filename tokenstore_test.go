@@ -0,0 +1,128 @@
+/*
+ * Copyright 2025 Matthew Gall <me@matthewgall.dev>
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/machinebox/graphql"
+)
+
+func TestTokenStore_RoundTrip(t *testing.T) {
+	store := newTokenStore(t.TempDir())
+	token := &storedToken{
+		Token:         "test-token",
+		TokenExpiry:   time.Date(2025, 1, 1, 13, 0, 0, 0, time.UTC),
+		RefreshToken:  "test-refresh",
+		RefreshExpiry: time.Date(2025, 1, 8, 12, 0, 0, 0, time.UTC),
+	}
+
+	if err := store.save("sk_live_one", token); err != nil {
+		t.Fatalf("Failed to save token: %v", err)
+	}
+
+	loaded, err := store.load("sk_live_one")
+	if err != nil {
+		t.Fatalf("Failed to load token: %v", err)
+	}
+	if loaded == nil || loaded.Token != token.Token || loaded.RefreshToken != token.RefreshToken || !loaded.TokenExpiry.Equal(token.TokenExpiry) {
+		t.Errorf("Expected %+v, got %+v", token, loaded)
+	}
+
+	info, err := os.Stat(store.path("sk_live_one"))
+	if err != nil {
+		t.Fatalf("Failed to stat token file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected token file mode 0600, got %o", info.Mode().Perm())
+	}
+
+	data, _ := os.ReadFile(store.path("sk_live_one"))
+	if strings.Contains(string(data), "test-token") || strings.Contains(store.path("sk_live_one"), "sk_live_one") {
+		t.Error("Expected token file and name not to reveal the token or API key")
+	}
+
+	if other, err := store.load("sk_live_two"); err != nil || other != nil {
+		t.Errorf("Expected no token for a different API key, got %+v, %v", other, err)
+	}
+}
+
+func TestTokenStore_WrongKey(t *testing.T) {
+	store := newTokenStore(t.TempDir())
+	if err := store.save("sk_live_one", &storedToken{Token: "test-token"}); err != nil {
+		t.Fatalf("Failed to save token: %v", err)
+	}
+
+	// A file copied under another key's name must not decrypt
+	data, _ := os.ReadFile(store.path("sk_live_one"))
+	os.WriteFile(store.path("sk_live_two"), data, 0600)
+
+	if _, err := store.load("sk_live_two"); err == nil {
+		t.Error("Expected error decrypting with the wrong API key, got nil")
+	}
+}
+
+func TestTokenStore_ConcurrentSaves(t *testing.T) {
+	dir := t.TempDir()
+	store := newTokenStore(dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := store.save("sk_live_one", &storedToken{Token: fmt.Sprintf("token-%d", i)}); err != nil {
+				t.Errorf("Failed to save token: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Whichever write lands last, the file is whole
+	if loaded, err := store.load("sk_live_one"); err != nil || loaded == nil || !strings.HasPrefix(loaded.Token, "token-") {
+		t.Errorf("Expected a complete token, got %+v, %v", loaded, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("Expected only the token file, got %d entries", len(entries))
+	}
+}
+
+func TestAuthenticatedClient_ReusesStoredToken(t *testing.T) {
+	fake := &tokenTestServer{tokenExpiry: time.Hour}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store := newTokenStore(t.TempDir())
+	for run := 0; run < 2; run++ {
+		client := NewAuthenticatedClient("test-api-key", server.URL, WithTokenStore(store))
+		var resp map[string]interface{}
+		if err := client.Run(context.Background(), graphql.NewRequest("query { ok }"), &resp); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if fake.issued != 1 {
+		t.Errorf("Expected the second run to reuse the stored token, got %d tokens issued", fake.issued)
+	}
+}
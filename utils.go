@@ -19,14 +19,10 @@ package main
 import (
 	"log/slog"
 	"os"
+	"path/filepath"
 	"runtime/debug"
 )
 
-const (
-	graphqlEndpoint = "https://api.octopus.energy/v1/graphql/"
-	davidKendallAPI = "https://oe-api.davidskendall.co.uk/free_electricity.json"
-)
-
 // These variables are set at build time using ldflags
 var (
 	buildVersion = "dev"
@@ -67,6 +63,29 @@ func GetVersion() string {
 	return "dev"
 }
 
+// writeFileAtomic replaces a file in one step, through a temporary file in
+// the same directory, so an interrupted run never leaves it half written
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	temp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), perm); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), filename)
+}
+
 // GetUserAgent returns a user agent string for HTTP requests
 func GetUserAgent() string {
 	return "matthewgall/octoevents/" + GetVersion()